@@ -0,0 +1,6 @@
+# The layout is a list of buckets, each mapping item keys to weights
+# The first plan adopts the layout as is, rebalance_trigger and bucket_remap only apply to later changes
+terraform import persistent_buckets.example '[{"item-1": 50, "item-2": 25}, {"item-4": 50}]'
+
+# The layout can also be read from a file
+terraform import persistent_buckets.example ./layout.json
@@ -8,32 +8,40 @@ import (
 func assignKeys(keys []string, state map[string]int64, reuse bool, initial int64, last int64) (int64, map[string]int64) {
 	// Create a map to hold the assigned values
 	assignedValues := make(map[string]int64, len(keys))
-	// Create a list of values for easier tracking for the next possible one
-	values := make([]int64, 0, len(keys))
+	// Create a set of values for easier tracking for the next possible one
+	values := make(map[int64]bool, len(keys))
+	keySet := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keySet[key] = true
+	}
 
 	// If the previous state is defined, maintain all entries that are still present in keys.
 	// Also handle a changing initial value.
 	for key, value := range state {
-		if slices.Contains(keys, key) && value >= initial {
+		if keySet[key] && value >= initial {
 			assignedValues[key] = value
-			values = append(values, value)
+			values[value] = true
 		}
 	}
 
 	// Sort keys to provide a predictable behaviour
 	slices.Sort(keys)
 
+	// Values are only added, so the lowest free value never decreases
+	next := initial
+
 	// Iterate over the keys and provide values to those not covered yet
 	for _, key := range keys {
 		// If the key has not yet a value assigned
 		if _, exists := assignedValues[key]; !exists {
 			// If reuse is true, find a value that does not exist in the assignedValues map
 			if reuse {
-				for i := initial; ; i++ {
-					if !slices.Contains(values, i) {
+				for i := next; ; i++ {
+					if !values[i] {
 						assignedValues[key] = i
-						values = append(values, i)
+						values[i] = true
 						last = i
+						next = i + 1
 						break
 					}
 				}
@@ -1,11 +1,15 @@
 package provider
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"maps"
+	"math"
 	"net/http"
 	"slices"
 	"sort"
+	"strings"
 
 	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
@@ -13,26 +17,35 @@ import (
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
-	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/schema/validator"
 	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
 
 	"github.com/hashicorp/terraform-plugin-framework/types"
 
+	"github.com/hashicorp/terraform-plugin-framework-validators/float64validator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/int64validator"
+	"github.com/hashicorp/terraform-plugin-framework-validators/listvalidator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/mapvalidator"
 	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
 )
 
 var _ resource.Resource = &PersistentBucketsResource{}
 var _ resource.ResourceWithImportState = &PersistentBucketsResource{}
+var _ resource.ResourceWithModifyPlan = &PersistentBucketsResource{}
+
+const (
+	overflowError  = "error"
+	overflowReport = "report"
+)
 
 var itemObjectType = types.ObjectType{
 	AttrTypes: map[string]attr.Type{
 		"weight": types.Int64Type,
 		"item":   types.StringType,
+		"slot":   types.Int64Type,
 	},
 }
 
@@ -40,6 +53,14 @@ var bucketsType = types.MapType{
 	ElemType: itemObjectType,
 }
 
+var moveObjectType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"item": types.StringType,
+		"from": types.Int64Type,
+		"to":   types.Int64Type,
+	},
+}
+
 var nestedItem = schema.NestedAttributeObject{
 	Attributes: map[string]schema.Attribute{
 		"weight": schema.Int64Attribute{
@@ -53,12 +74,79 @@ var nestedItem = schema.NestedAttributeObject{
 			Optional:    true,
 			Description: "Data for the item",
 		},
+		"affinity_group": schema.StringAttribute{
+			Optional:    true,
+			Description: "Items sharing the same affinity group are always placed in the same bucket.",
+			Validators: []validator.String{
+				stringvalidator.LengthAtLeast(1),
+			},
+		},
+		"anti_affinity_group": schema.StringAttribute{
+			Optional:    true,
+			Description: "Items sharing the same anti-affinity group are never placed in the same bucket.",
+			Validators: []validator.String{
+				stringvalidator.LengthAtLeast(1),
+			},
+		},
+		"reserved_weight": schema.Int64Attribute{
+			Optional:    true,
+			Description: "Weight reserved for the item in its bucket. The item takes up the larger of `weight` and `reserved_weight` when placing items, so it can grow up to the reserved weight without being moved.",
+			Validators: []validator.Int64{
+				int64validator.AtLeast(1),
+			},
+		},
+		"required_labels": schema.MapAttribute{
+			ElementType: types.StringType,
+			Optional:    true,
+			Description: "Labels a bucket must have to hold the item, see `bucket_labels`.",
+		},
+		"preferred_labels": schema.MapAttribute{
+			ElementType: types.StringType,
+			Optional:    true,
+			Description: "Labels of the buckets the item is preferably placed in, see `bucket_labels`. Items are placed in other buckets when there is no room in the preferred ones.",
+		},
+		"replicas": schema.Int64Attribute{
+			Optional:    true,
+			Description: "Number of replicas of the item. Each replica takes the weight of the item in a different bucket and is listed as `<key>#<index>`.",
+			Validators: []validator.Int64{
+				int64validator.AtLeast(1),
+			},
+		},
+		"divisible": schema.BoolAttribute{
+			Optional:    true,
+			Description: "Splits the item into chunks that may be placed in several buckets, listed in `chunks`. The chunks fill the capacity left by the other items and stay in their buckets when the weight changes, growth is added to the existing chunks first. Chunks are trimmed when their bucket has less room left and moved out of buckets that are evacuated.",
+		},
+		"min_chunk": schema.Int64Attribute{
+			Optional:    true,
+			Description: "Smallest chunk of a divisible item placed in a bucket (defaults to 1).",
+			Validators: []validator.Int64{
+				int64validator.AtLeast(1),
+			},
+		},
+		"priority": schema.Int64Attribute{
+			Optional:    true,
+			Description: "Priority of the item (defaults to 0). When the buckets are full, a new item may evict items of lower priority into `unplaced_items`.",
+		},
 	},
 }
 
 type BucketItem struct {
-	Weight int64
-	Item   string
+	Weight            int64
+	Item              string
+	AffinityGroup     string
+	AntiAffinityGroup string
+	Priority          int64
+	ReservedWeight    int64
+	ReplicaOf         string
+	RequiredLabels    map[string]string
+	PreferredLabels   map[string]string
+	Divisible         bool
+	MinChunk          int64
+}
+
+// footprint is the weight the item takes in its bucket, including the reserved weight
+func (i BucketItem) footprint() int64 {
+	return max(i.Weight, i.ReservedWeight)
 }
 
 func NewPersistentBucketsResource() resource.Resource {
@@ -70,13 +158,42 @@ type PersistentBucketsResource struct {
 }
 
 type PersistentBucketsResourceModel struct {
-	Id             types.String `tfsdk:"id"`
-	Items          types.Map    `tfsdk:"items"`
-	MaximumBuckets types.Int64  `tfsdk:"maximum_buckets"`
-	BucketCapacity types.Int64  `tfsdk:"bucket_capacity"`
-	TargetCapacity types.Int64  `tfsdk:"target_capacity"`
-	MoveItems      types.Bool   `tfsdk:"move_items"`
-	Buckets        types.List   `tfsdk:"buckets"`
+	Id                types.String  `tfsdk:"id"`
+	Items             types.Map     `tfsdk:"items"`
+	MaximumBuckets    types.Int64   `tfsdk:"maximum_buckets"`
+	BucketCapacity    types.Int64   `tfsdk:"bucket_capacity"`
+	TargetCapacity    types.Int64   `tfsdk:"target_capacity"`
+	MoveItems         types.Bool    `tfsdk:"move_items"`
+	RebalanceTrigger  types.String  `tfsdk:"rebalance_trigger"`
+	RebalanceStrategy types.String  `tfsdk:"rebalance_strategy"`
+	BucketRemap       types.Object  `tfsdk:"bucket_remap"`
+	MaxMovesPerApply  types.Int64   `tfsdk:"max_moves_per_apply"`
+	Buckets           types.List    `tfsdk:"buckets"`
+	LastMoves         types.List    `tfsdk:"last_moves"`
+	Placements        types.Map     `tfsdk:"placements"`
+	Utilization       types.List    `tfsdk:"utilization"`
+	FreeCapacity      types.List    `tfsdk:"free_capacity"`
+	MinimumBuckets    types.Int64   `tfsdk:"minimum_buckets"`
+	ShrinkEmpty       types.Bool    `tfsdk:"shrink_empty"`
+	MaxItemsPerBucket types.Int64   `tfsdk:"max_items_per_bucket"`
+	DrainingBuckets   types.List    `tfsdk:"draining_buckets"`
+	Evacuate          types.Bool    `tfsdk:"evacuate"`
+	BucketStatus      types.List    `tfsdk:"bucket_status"`
+	UnplacedItems     types.Map     `tfsdk:"unplaced_items"`
+	OnOverflow        types.String  `tfsdk:"on_overflow"`
+	OvercommitRatio   types.Float64 `tfsdk:"overcommit_ratio"`
+	BucketOvercommit  types.List    `tfsdk:"bucket_overcommit_ratios"`
+	Payloads          types.Dynamic `tfsdk:"payloads"`
+	BucketPayloads    types.Dynamic `tfsdk:"bucket_payloads"`
+	AllocateOffsets   types.Bool    `tfsdk:"allocate_offsets"`
+	Offsets           types.Map     `tfsdk:"offsets"`
+	Fragmentation     types.List    `tfsdk:"fragmentation"`
+	SlotsPerBucket    types.Int64   `tfsdk:"slots_per_bucket"`
+	ReservedCapacity  types.List    `tfsdk:"reserved_capacity"`
+	BucketLabels      types.List    `tfsdk:"bucket_labels"`
+	MoveCost          types.String  `tfsdk:"move_cost"`
+	RepairState       types.Bool    `tfsdk:"repair_state"`
+	Chunks            types.List    `tfsdk:"chunks"`
 }
 
 func (r *PersistentBucketsResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -90,7 +207,11 @@ func (r *PersistentBucketsResource) Schema(ctx context.Context, req resource.Sch
 			defined according to bucket capacity and item size. Once a bucket's capacity
 			is exhausted, new buckets are created up until ` + "`maximum_buckets`" + `. If items are
 			removed, they are also removed from buckets and new items may be placed in 
-			the freed space. If maximum buckets are reached, an error is raised.
+			the freed space. If maximum buckets are reached, an error is raised
+			unless ` + "`on_overflow`" + ` is set to ` + "`report`" + `.
+
+			The buckets are calculated while planning, so the plan shows where items are
+			placed and lists every item that is moved to another bucket.
 		`,
 
 		Attributes: map[string]schema.Attribute{
@@ -111,37 +232,68 @@ func (r *PersistentBucketsResource) Schema(ctx context.Context, req resource.Sch
 			},
 			"maximum_buckets": schema.Int64Attribute{
 				Required:    true,
-				Description: "Maximum number of buckets to provision.",
+				Description: "Maximum number of buckets to provision. When shrunk, trailing buckets are removed and their items are moved to the remaining buckets (requires `move_items`).",
 				Validators: []validator.Int64{
 					int64validator.AtLeast(1),
 				},
-				PlanModifiers: []planmodifier.Int64{
-					int64planmodifier.RequiresReplaceIf(func(ctx context.Context, req planmodifier.Int64Request, resp *int64planmodifier.RequiresReplaceIfFuncResponse) {
-						if req.PlanValue.ValueInt64() < req.ConfigValue.ValueInt64() {
-							resp = &int64planmodifier.RequiresReplaceIfFuncResponse{
-								RequiresReplace: true,
-							}
-						} else {
-							resp = &int64planmodifier.RequiresReplaceIfFuncResponse{
-								RequiresReplace: true,
-							}
-						}
-					}, "Replace resource if number of buckets is shrunk.", "Replace resource if number of buckets is shrunk."),
+			},
+			"minimum_buckets": schema.Int64Attribute{
+				Optional:    true,
+				Description: "Minimum number of buckets to provision. If set, buckets are only added as they are needed up until `maximum_buckets`, otherwise `maximum_buckets` buckets are always provisioned.",
+				Validators: []validator.Int64{
+					int64validator.AtLeast(0),
 				},
 			},
+			"shrink_empty": schema.BoolAttribute{
+				Optional:    true,
+				Computed:    true,
+				Default:     booldefault.StaticBool(false),
+				Description: "Removes empty buckets from the end of the list down to `minimum_buckets`. Only applies if `minimum_buckets` is set.",
+			},
 			"bucket_capacity": schema.Int64Attribute{
 				Required:    true,
-				Description: "Capacity of a single bucket.",
+				Description: "Capacity of a single bucket. When lowered, only the items needed to fit the new capacity are moved out of overfull buckets.",
 				Validators: []validator.Int64{
 					int64validator.AtLeast(1),
 				},
-				PlanModifiers: []planmodifier.Int64{
-					int64planmodifier.RequiresReplace(),
-				},
 			},
 			"target_capacity": schema.Int64Attribute{
 				Optional:    true,
-				Description: "Target capacity of a single bucket (fills bucket up to this capacity, allows room for items growing weight without needing to move).",
+				Description: "Target capacity of a single bucket (fills bucket up to this capacity, allows room for items growing weight without needing to move). Can't exceed `bucket_capacity`.",
+				Validators: []validator.Int64{
+					int64validator.AtLeast(1),
+				},
+			},
+			"overcommit_ratio": schema.Float64Attribute{
+				Optional:    true,
+				Description: "Ratio to overcommit the capacity of the buckets with. Items are placed up to `bucket_capacity` (and `target_capacity`) multiplied by the ratio.",
+				Validators: []validator.Float64{
+					float64validator.AtLeast(1),
+				},
+			},
+			"bucket_overcommit_ratios": schema.ListAttribute{
+				ElementType: types.Float64Type,
+				Optional:    true,
+				Description: "Overcommit ratio of each bucket by index, overrides `overcommit_ratio`. Null elements use `overcommit_ratio`.",
+				Validators: []validator.List{
+					listvalidator.ValueFloat64sAre(float64validator.AtLeast(1)),
+				},
+			},
+			"bucket_labels": schema.ListAttribute{
+				ElementType: types.MapType{ElemType: types.StringType},
+				Optional:    true,
+				Description: "Labels of each bucket by index, matched against the `required_labels` and `preferred_labels` of the items.",
+			},
+			"max_items_per_bucket": schema.Int64Attribute{
+				Optional:    true,
+				Description: "Maximum number of items in a single bucket, enforced in addition to the bucket capacity.",
+				Validators: []validator.Int64{
+					int64validator.AtLeast(1),
+				},
+			},
+			"slots_per_bucket": schema.Int64Attribute{
+				Optional:    true,
+				Description: "Number of slots in a bucket. Each item is given the lowest free `slot` in its bucket, which stays the same while the item stays in the bucket. Also limits the number of items in a bucket.",
 				Validators: []validator.Int64{
 					int64validator.AtLeast(1),
 				},
@@ -152,13 +304,165 @@ func (r *PersistentBucketsResource) Schema(ctx context.Context, req resource.Sch
 				Default:     booldefault.StaticBool(true),
 				Description: "Allows moving items from one bucket to another (when weight of an item changes). If set to false, causes an error if an item needs moving.",
 			},
+			"rebalance_trigger": schema.StringAttribute{
+				Optional:    true,
+				Description: "Changing this value rebalances the items in the buckets according to `rebalance_strategy`.",
+			},
+			"rebalance_strategy": schema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Default:     stringdefault.StaticString(rebalancePack),
+				Description: "Rebalancing strategy: `pack` moves items to use as few buckets as possible, `spread` evens out the utilization of the buckets.",
+				Validators: []validator.String{
+					stringvalidator.OneOf(rebalancePack, rebalanceSpread),
+				},
+			},
+			"bucket_remap": schema.SingleNestedAttribute{
+				Optional:    true,
+				Description: "Maps the buckets in the state to new indexes once, when `trigger` changes, e.g. when the bucket capacity is doubled or halved. Exactly one of `mapping`, `merge` and `split` must be set. Buckets that are over their capacity after the remap are reported, and their items are moved like after a change of `bucket_capacity`.",
+				Attributes: map[string]schema.Attribute{
+					"trigger": schema.StringAttribute{
+						Required:    true,
+						Description: "Changing this value applies the remap to the buckets in the state.",
+					},
+					"mapping": schema.ListAttribute{
+						ElementType: types.Int64Type,
+						Optional:    true,
+						Description: "New index of each bucket in the state. Buckets past the end of the list keep their index.",
+						Validators: []validator.List{
+							listvalidator.ValueInt64sAre(int64validator.AtLeast(0)),
+						},
+					},
+					"merge": schema.Int64Attribute{
+						Optional:    true,
+						Description: "Merges every `merge` consecutive buckets into one, bucket `i` becomes bucket `i / merge`.",
+						Validators: []validator.Int64{
+							int64validator.AtLeast(2),
+						},
+					},
+					"split": schema.Int64Attribute{
+						Optional:    true,
+						Description: "Splits every bucket into `split` consecutive buckets, the items of bucket `i` are spread over buckets `i * split` to `i * split + split - 1` in key order, filling each bucket up to its capacity.",
+						Validators: []validator.Int64{
+							int64validator.AtLeast(2),
+						},
+					},
+				},
+			},
+			"move_cost": schema.StringAttribute{
+				Optional:    true,
+				Description: "When an item grows past the capacity of its bucket, searches for the cheapest set of items to move out of the bucket instead of moving the grown item: `minimize_weight` moves the least weight, `minimize_count` moves the fewest items. The search is bounded, the best set found so far is used when the bound is reached.",
+				Validators: []validator.String{
+					stringvalidator.OneOf(moveCostWeight, moveCostCount),
+				},
+			},
+			"max_moves_per_apply": schema.Int64Attribute{
+				Optional:    true,
+				Description: "Maximum number of items moved when rebalancing or evacuating draining buckets, each chunk of a `divisible` item moved out of a draining bucket counts as one move.",
+				Validators: []validator.Int64{
+					int64validator.AtLeast(0),
+				},
+			},
+			"draining_buckets": schema.ListAttribute{
+				ElementType: types.Int64Type,
+				Optional:    true,
+				Description: "Indexes of buckets that don't receive any new items. Existing items stay in place unless `evacuate` is set.",
+				Validators: []validator.List{
+					listvalidator.ValueInt64sAre(int64validator.AtLeast(0)),
+				},
+			},
+			"evacuate": schema.BoolAttribute{
+				Optional:    true,
+				Computed:    true,
+				Default:     booldefault.StaticBool(false),
+				Description: "Moves items out of the draining buckets, up to `max_moves_per_apply` items per apply.",
+			},
+			"on_overflow": schema.StringAttribute{
+				Optional:    true,
+				Computed:    true,
+				Default:     stringdefault.StaticString(overflowError),
+				Description: "What to do with items that don't fit in any bucket: `error` fails the plan, `report` leaves them out of the buckets and lists them in `unplaced_items`.",
+				Validators: []validator.String{
+					stringvalidator.OneOf(overflowError, overflowReport),
+				},
+			},
+			"repair_state": schema.BoolAttribute{
+				Optional:    true,
+				Computed:    true,
+				Default:     booldefault.StaticBool(false),
+				Description: "Repairs a state where an item is in more than one bucket by keeping its first occurrence. Buckets over their capacity or past `maximum_buckets` in the state are then reported as warnings and fixed by the next plan.",
+			},
+			"payloads": schema.DynamicAttribute{
+				Optional:    true,
+				Description: "Structured payload of the items as an object or map keyed by the item key. Payloads are carried unchanged into `bucket_payloads` and never cause items to move, `divisible` items can't have a payload. They are kept separate from `items` and `buckets` because dynamic values can't be nested inside a map attribute.",
+			},
+			"bucket_payloads": schema.DynamicAttribute{
+				Computed:    true,
+				Description: "Payloads of the items in each bucket, in the same order as `buckets`.",
+			},
+			"allocate_offsets": schema.BoolAttribute{
+				Optional:    true,
+				Computed:    true,
+				Default:     booldefault.StaticBool(false),
+				Description: "Allocates each item a contiguous range of its weight inside its bucket, listed in `offsets`. Items keep their offset while they stay in the same bucket and new items fill the holes first-fit.",
+			},
+			"offsets": schema.MapAttribute{
+				ElementType: types.Int64Type,
+				Computed:    true,
+				Description: "Offset of each item inside its bucket when `allocate_offsets` is set. The item occupies the range `[offset, offset+weight)`, extended up to its `reserved_weight`.",
+			},
+			"fragmentation": schema.ListAttribute{
+				ElementType: types.Float64Type,
+				Computed:    true,
+				Description: "Fragmentation of each bucket when `allocate_offsets` is set: the share of the free space outside of the largest hole.",
+			},
 			"buckets": schema.ListAttribute{
 				ElementType: types.MapType{
 					ElemType: itemObjectType,
 				},
 				Optional:    true,
 				Computed:    true,
-				Description: "Ordered list of filled buckets.",
+				Description: "Ordered list of filled buckets. Items have a `slot` when `slots_per_bucket` is set.",
+			},
+			"last_moves": schema.ListAttribute{
+				ElementType: moveObjectType,
+				Computed:    true,
+				Description: "Items that were moved from one bucket to another by the last apply.",
+			},
+			"placements": schema.MapAttribute{
+				ElementType: types.Int64Type,
+				Computed:    true,
+				Description: "Index of the bucket each item is placed in.",
+			},
+			"utilization": schema.ListAttribute{
+				ElementType: types.Int64Type,
+				Computed:    true,
+				Description: "Used weight of each bucket. Can exceed `bucket_capacity` when the bucket is overcommitted.",
+			},
+			"reserved_capacity": schema.ListAttribute{
+				ElementType: types.Int64Type,
+				Computed:    true,
+				Description: "Weight reserved in each bucket for the items to grow into, on top of `utilization`.",
+			},
+			"chunks": schema.ListAttribute{
+				ElementType: chunksType,
+				Computed:    true,
+				Description: "Chunks of the divisible items in each bucket, in the same order as `buckets`.",
+			},
+			"free_capacity": schema.ListAttribute{
+				ElementType: types.Int64Type,
+				Computed:    true,
+				Description: "Remaining weight of each bucket against `bucket_capacity`, after the reserved weight. Negative when an overcommitted bucket exceeds its physical capacity.",
+			},
+			"bucket_status": schema.ListAttribute{
+				ElementType: types.StringType,
+				Computed:    true,
+				Description: "Status of each bucket: `active`, `draining` or `drained` (draining and empty).",
+			},
+			"unplaced_items": schema.MapAttribute{
+				ElementType: itemObjectType,
+				Computed:    true,
+				Description: "Items that are not placed in any bucket, because they were evicted by items of higher priority or didn't fit when `on_overflow` is `report`.",
 			},
 		},
 	}
@@ -184,10 +488,11 @@ func (r *PersistentBucketsResource) Configure(ctx context.Context, req resource.
 	r.client = client
 }
 
-func createItem(weight int64, item string, diagnostics *diag.Diagnostics) *basetypes.ObjectValue {
+func createItem(weight int64, item string, slot types.Int64, diagnostics *diag.Diagnostics) *basetypes.ObjectValue {
 	obj, diags := types.ObjectValue(itemObjectType.AttrTypes, map[string]attr.Value{
 		"weight": types.Int64Value(weight),
 		"item":   types.StringValue(item),
+		"slot":   slot,
 	})
 	if diags.HasError() {
 		return nil
@@ -196,189 +501,1456 @@ func createItem(weight int64, item string, diagnostics *diag.Diagnostics) *baset
 	return &obj
 }
 
-func findCapacity(capacities *[]int64, weight int64, maxCapacity int64) *int {
+func findCapacity(capacities *[]int64, weight int64, limits []int64, eligible func(int) bool) *int {
 	for k, cap := range *capacities {
-		if (cap + weight) <= maxCapacity {
+		if (cap+weight) <= limits[k] && (eligible == nil || eligible(k)) {
 			return &k
 		}
 	}
 	return nil
 }
 
-func workTheBuckets(data, state *PersistentBucketsResourceModel, diagnostics *diag.Diagnostics) {
-	data.Buckets = basetypes.NewListNull(bucketsType)
-	capacities := make([]int64, data.MaximumBuckets.ValueInt64())
-	allBuckets := make([]map[string]BucketItem, data.MaximumBuckets.ValueInt64())
-	for idx := 0; idx < int(data.MaximumBuckets.ValueInt64()); idx++ {
-		allBuckets[idx] = make(map[string]BucketItem, 0)
-		capacities[idx] = 0
+// itemSlot returns the slot of an item in the state
+func itemSlot(v attr.Value) (int64, bool) {
+	vv, ok := v.(basetypes.ObjectValue)
+	if !ok {
+		return 0, false
 	}
-	bucketCapacity := data.BucketCapacity.ValueInt64()
-	targetCapacity := bucketCapacity
-	if !data.BucketCapacity.IsUnknown() && !data.BucketCapacity.IsNull() {
-		if data.TargetCapacity.ValueInt64() > 0 {
-			targetCapacity = data.TargetCapacity.ValueInt64()
-		}
+	slot, ok := vv.Attributes()["slot"].(basetypes.Int64Value)
+	if !ok || slot.IsNull() || slot.IsUnknown() {
+		return 0, false
 	}
+	return slot.ValueInt64(), true
+}
 
-	keysInBuckets := make(map[string]int, 0)
+// itemReplicas returns the number of replicas of an item in the configuration
+func itemReplicas(v attr.Value) (int64, bool) {
+	vv, ok := v.(basetypes.ObjectValue)
+	if !ok {
+		return 0, false
+	}
+	replicas, ok := vv.Attributes()["replicas"].(basetypes.Int64Value)
+	if !ok || replicas.IsNull() || replicas.IsUnknown() {
+		return 0, false
+	}
+	return replicas.ValueInt64(), true
+}
 
-	// Fill buckets from TF data
-	if state != nil && !state.Buckets.IsUnknown() {
-		for bidx, bucket := range state.Buckets.Elements() {
-			if bucketItems, ok := bucket.(basetypes.MapValue); ok {
-				for k, v := range bucketItems.Elements() {
-					if vv, ok := v.(basetypes.ObjectValue); ok {
-						objAttrs := vv.Attributes()
-						allBuckets[bidx][k] = BucketItem{
-							Weight: objAttrs["weight"].(basetypes.Int64Value).ValueInt64(),
-							Item:   objAttrs["item"].(basetypes.StringValue).ValueString(),
-						}
-						capacities[bidx] += allBuckets[bidx][k].Weight
-						keysInBuckets[k] = bidx
-					}
-				}
-			}
-		}
+// replicaKey returns the key of a replica of an item
+func replicaKey(key string, idx int64) string {
+	return fmt.Sprintf("%s#%d", key, idx)
+}
+
+// overcommitLimits returns the effective capacity of each bucket with its overcommit ratio applied
+func overcommitLimits(capacity int64, ratios []float64) []int64 {
+	limits := make([]int64, len(ratios))
+	for idx, ratio := range ratios {
+		limits[idx] = int64(math.Floor(float64(capacity) * ratio))
 	}
+	return limits
+}
 
-	keysDefined := make([]string, 0)
-	newItems := make(map[string]BucketItem, 0)
-	for k, v := range data.Items.Elements() {
-		keysDefined = append(keysDefined, k)
-		if _, ok := keysInBuckets[k]; !ok {
-			if vv, ok := v.(basetypes.ObjectValue); ok {
-				objAttrs := vv.Attributes()
-				newItems[k] = BucketItem{
-					Weight: objAttrs["weight"].(basetypes.Int64Value).ValueInt64(),
-					Item:   objAttrs["item"].(basetypes.StringValue).ValueString(),
-				}
-			}
-		} else {
-			// Adjust bucket capacities
-			keyInBucket := keysInBuckets[k]
-			previousWeight := allBuckets[keyInBucket][k].Weight
-
-			if vv, ok := v.(basetypes.ObjectValue); ok {
-				objAttrs := vv.Attributes()
-				newItem := BucketItem{
-					Weight: objAttrs["weight"].(basetypes.Int64Value).ValueInt64(),
-					Item:   objAttrs["item"].(basetypes.StringValue).ValueString(),
-				}
-				newWeight := newItem.Weight
+// parseItem converts an item object from the configuration or the state into a BucketItem
+func parseItem(v attr.Value) (BucketItem, bool) {
+	vv, ok := v.(basetypes.ObjectValue)
+	if !ok {
+		return BucketItem{}, false
+	}
+	objAttrs := vv.Attributes()
+	item := BucketItem{
+		Weight: objAttrs["weight"].(basetypes.Int64Value).ValueInt64(),
+		Item:   objAttrs["item"].(basetypes.StringValue).ValueString(),
+	}
+	if group, ok := objAttrs["affinity_group"].(basetypes.StringValue); ok {
+		item.AffinityGroup = group.ValueString()
+	}
+	if group, ok := objAttrs["anti_affinity_group"].(basetypes.StringValue); ok {
+		item.AntiAffinityGroup = group.ValueString()
+	}
+	if priority, ok := objAttrs["priority"].(basetypes.Int64Value); ok {
+		item.Priority = priority.ValueInt64()
+	}
+	if reserved, ok := objAttrs["reserved_weight"].(basetypes.Int64Value); ok {
+		item.ReservedWeight = reserved.ValueInt64()
+	}
+	if labels, ok := objAttrs["required_labels"]; ok {
+		item.RequiredLabels = stringMap(labels)
+	}
+	if labels, ok := objAttrs["preferred_labels"]; ok {
+		item.PreferredLabels = stringMap(labels)
+	}
+	if divisible, ok := objAttrs["divisible"].(basetypes.BoolValue); ok {
+		item.Divisible = divisible.ValueBool()
+	}
+	if minChunk, ok := objAttrs["min_chunk"].(basetypes.Int64Value); ok {
+		item.MinChunk = minChunk.ValueInt64()
+	}
+	return item, true
+}
 
-				capacities[keyInBucket] -= previousWeight
-				// Check if new weight would require moving the item to a new bucket
-				if (capacities[keyInBucket] + newWeight) > bucketCapacity {
-					newBucket := findCapacity(&capacities, newWeight, bucketCapacity)
-					if newBucket == nil {
-						diagnostics.AddError(fmt.Sprintf("unable to find bucket capacity for: %s (previous weight %d, new weight %d)", k, previousWeight, newWeight), fmt.Sprintf("bucket capacities: %+v", capacities))
-						return
-					}
-					if !data.MoveItems.ValueBool() {
-						diagnostics.AddError(fmt.Sprintf("unable to find bucket capacity for moving item: %s (previous weight %d, new weight %d)", k, previousWeight, newWeight), fmt.Sprintf("bucket capacities: %+v", capacities))
-						return
-					}
-					delete(allBuckets[keyInBucket], k)
-					keysInBuckets[k] = *newBucket
-					allBuckets[*newBucket][k] = newItem
-					capacities[*newBucket] += newWeight
-				} else {
-					allBuckets[keyInBucket][k] = newItem
-					capacities[keyInBucket] += newWeight
-				}
+// antiAffinityConflict checks if any of the items shares an anti-affinity group with
+// an item already in the bucket
+func antiAffinityConflict(bucket map[string]BucketItem, items map[string]BucketItem) bool {
+	for _, v := range items {
+		if v.AntiAffinityGroup == "" {
+			continue
+		}
+		for k, bv := range bucket {
+			if _, ok := items[k]; ok {
+				continue
+			}
+			if bv.AntiAffinityGroup == v.AntiAffinityGroup {
+				return true
 			}
 		}
 	}
-
-	// Remove items
-	for bidx, bucket := range allBuckets {
-		for k, v := range bucket {
-			if !slices.Contains(keysDefined, k) {
-				capacities[bidx] -= v.Weight
-				delete(allBuckets[bidx], k)
+	// Replicas of an item are never placed in the same bucket
+	for _, v := range items {
+		if v.ReplicaOf == "" {
+			continue
+		}
+		for k, bv := range bucket {
+			if _, ok := items[k]; ok {
+				continue
+			}
+			if bv.ReplicaOf == v.ReplicaOf {
+				return true
 			}
 		}
 	}
+	return false
+}
 
-	// Sort keys to make more predictable results
-	newItemsKeys := make([]string, 0)
-	for k := range newItems {
-		newItemsKeys = append(newItemsKeys, k)
+// affinityBucket returns the first bucket holding one of the items of the affinity group, or -1
+func affinityBucket(keysInBuckets map[string]int, members []string) int {
+	bucket := -1
+	for _, k := range members {
+		if bidx, ok := keysInBuckets[k]; ok && (bucket < 0 || bidx < bucket) {
+			bucket = bidx
+		}
 	}
-	sort.Strings(newItemsKeys)
+	return bucket
+}
 
-	// Add new items in buckets with capacity
-	for _, k := range newItemsKeys {
-		v := newItems[k]
-		targetBucket := findCapacity(&capacities, v.Weight, targetCapacity)
-		if targetBucket == nil {
-			diagnostics.AddError(fmt.Sprintf("unable to find bucket capacity for: %s (weight %d)", k, v.Weight), fmt.Sprintf("bucket capacities: %+v", capacities))
-			return
+// affinityUnit returns the item together with the items sharing its affinity group in the bucket
+func affinityUnit(bucket map[string]BucketItem, key string) (map[string]BucketItem, int64) {
+	item := bucket[key]
+	unit := map[string]BucketItem{key: item}
+	weight := item.footprint()
+	if item.AffinityGroup != "" {
+		for k, v := range bucket {
+			if k != key && v.AffinityGroup == item.AffinityGroup {
+				unit[k] = v
+				weight += v.footprint()
+			}
 		}
-		allBuckets[*targetBucket][k] = v
-		capacities[*targetBucket] += v.Weight
 	}
+	return unit, weight
+}
 
-	// Generate output data
-	tfBuckets := make([]attr.Value, 0)
-	for _, items := range allBuckets {
-		tfItems := make(map[string]attr.Value, 0)
-		for k, v := range items {
-			tfItem := createItem(v.Weight, v.Item, diagnostics)
-			if tfItem == nil {
-				diagnostics.AddError(fmt.Sprintf("failed to create a map item for: %s", k), fmt.Sprintf("item: %s", v.Item))
-				return
-			}
-			tfItems[k] = *tfItem
+// bucketUnits splits the bucket into units of items that are moved together, ordered by key
+func bucketUnits(bucket map[string]BucketItem) ([]map[string]BucketItem, []int64) {
+	units := make([]map[string]BucketItem, 0)
+	weights := make([]int64, 0)
+	seen := make(map[string]bool, 0)
+	for _, k := range slices.Sorted(maps.Keys(bucket)) {
+		if seen[k] {
+			continue
 		}
-		bucketMap, diags := types.MapValue(itemObjectType, tfItems)
-		if diags.HasError() {
-			return
+		unit, weight := affinityUnit(bucket, k)
+		for uk := range unit {
+			seen[uk] = true
 		}
-		diagnostics.Append(diags...)
-
-		tfBuckets = append(tfBuckets, bucketMap)
+		units = append(units, unit)
+		weights = append(weights, weight)
 	}
+	return units, weights
+}
 
-	// Set output value
-	bucketsValue, diags := types.ListValue(bucketsType, tfBuckets)
-	if diags.HasError() {
-		diagnostics.AddError("failed to create buckets map for output", "")
-		return
+// moveItems moves the items from one bucket to another and updates the capacities
+func moveItems(allBuckets []map[string]BucketItem, capacities []int64, keysInBuckets map[string]int, items map[string]BucketItem, from, to int) {
+	for k, v := range items {
+		delete(allBuckets[from], k)
+		capacities[from] -= v.footprint()
+		allBuckets[to][k] = v
+		capacities[to] += v.footprint()
+		keysInBuckets[k] = to
 	}
-	data.Buckets = bucketsValue
-	diagnostics.Append(diags...)
 }
 
-func (r *PersistentBucketsResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
-	var data *PersistentBucketsResourceModel
-
-	// Read Terraform plan data into the model
-	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
-
-	if resp.Diagnostics.HasError() {
-		return
+// evictionUnits selects the affinity units to move out of a bucket to free up the overflowing
+// weight and the excess number of items. For the weight a single unit that is just large
+// enough is preferred, otherwise the largest units are picked until the overflow is covered.
+// Excess items are then covered by picking the lightest units.
+func evictionUnits(bucket map[string]BucketItem, overflow int64, excess int) []map[string]BucketItem {
+	units, weights := bucketUnits(bucket)
+	evicted := make([]map[string]BucketItem, 0)
+	for (overflow > 0 || excess > 0) && len(units) > 0 {
+		pick := -1
+		if overflow > 0 {
+			for idx, weight := range weights {
+				if weight >= overflow && (pick < 0 || weight < weights[pick]) {
+					pick = idx
+				}
+			}
+			if pick < 0 {
+				for idx, weight := range weights {
+					if pick < 0 || weight > weights[pick] {
+						pick = idx
+					}
+				}
+			}
+		} else {
+			for idx, weight := range weights {
+				if pick < 0 || weight < weights[pick] {
+					pick = idx
+				}
+			}
+		}
+		evicted = append(evicted, units[pick])
+		overflow -= weights[pick]
+		excess -= len(units[pick])
+		units = slices.Delete(units, pick, pick+1)
+		weights = slices.Delete(weights, pick, pick+1)
 	}
-	data.Id = types.StringValue("persistent_buckets")
-
-	workTheBuckets(data, nil, &resp.Diagnostics)
-
-	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+	return evicted
 }
 
-func (r *PersistentBucketsResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
-	var data *PersistentBucketsResourceModel
-
-	// Read Terraform prior state data into the model
-	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+// bucketMove describes an item that is moved from one bucket to another
+type bucketMove struct {
+	Key  string
+	From int
+	To   int
+}
 
-	if resp.Diagnostics.HasError() {
-		return
+// formatMoves lists the moves for diagnostics
+func formatMoves(moves []bucketMove) string {
+	lines := make([]string, 0, len(moves))
+	for _, move := range moves {
+		lines = append(lines, fmt.Sprintf("%s: bucket %d -> bucket %d", move.Key, move.From, move.To))
 	}
+	return strings.Join(lines, "\n")
+}
 
-	// Save updated data into Terraform state
-	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+// createMoves converts the moves into the last_moves list
+func createMoves(moves []bucketMove, diagnostics *diag.Diagnostics) basetypes.ListValue {
+	tfMoves := make([]attr.Value, 0, len(moves))
+	for _, move := range moves {
+		obj, diags := types.ObjectValue(moveObjectType.AttrTypes, map[string]attr.Value{
+			"item": types.StringValue(move.Key),
+			"from": types.Int64Value(int64(move.From)),
+			"to":   types.Int64Value(int64(move.To)),
+		})
+		diagnostics.Append(diags...)
+		tfMoves = append(tfMoves, obj)
+	}
+	movesValue, diags := types.ListValue(moveObjectType, tfMoves)
+	diagnostics.Append(diags...)
+	return movesValue
+}
+
+// payloadElements returns the payloads of the items from the payloads attribute
+func payloadElements(payloads types.Dynamic) (map[string]attr.Value, bool) {
+	if payloads.IsNull() || payloads.IsUnderlyingValueNull() {
+		return map[string]attr.Value{}, true
+	}
+	switch v := payloads.UnderlyingValue().(type) {
+	case basetypes.ObjectValue:
+		return v.Attributes(), true
+	case basetypes.MapValue:
+		return v.Elements(), true
+	}
+	return nil, false
+}
+
+// createPayloads converts the payloads of the items into a tuple of objects, one for each bucket
+func createPayloads(allBuckets []map[string]BucketItem, payloads map[string]attr.Value, diagnostics *diag.Diagnostics) basetypes.DynamicValue {
+	tfBuckets := make([]attr.Value, 0, len(allBuckets))
+	bucketTypes := make([]attr.Type, 0, len(allBuckets))
+	for _, items := range allBuckets {
+		attrTypes := make(map[string]attr.Type, 0)
+		attrValues := make(map[string]attr.Value, 0)
+		for k, v := range items {
+			payload, ok := payloads[k]
+			if !ok && v.ReplicaOf != "" {
+				// Replicas share the payload of their item
+				payload, ok = payloads[v.ReplicaOf]
+			}
+			if ok {
+				attrTypes[k] = payload.Type(context.Background())
+				attrValues[k] = payload
+			}
+		}
+		obj, diags := types.ObjectValue(attrTypes, attrValues)
+		diagnostics.Append(diags...)
+		tfBuckets = append(tfBuckets, obj)
+		bucketTypes = append(bucketTypes, obj.Type(context.Background()))
+	}
+	tuple, diags := types.TupleValue(bucketTypes, tfBuckets)
+	diagnostics.Append(diags...)
+	return types.DynamicValue(tuple)
+}
+
+// workTheBuckets places the items of the plan into buckets, starting from the layout in the state.
+// Returns the items that were moved between buckets.
+func workTheBuckets(data, state *PersistentBucketsResourceModel, diagnostics *diag.Diagnostics) []bucketMove {
+	data.Buckets = basetypes.NewListNull(bucketsType)
+	data.LastMoves = basetypes.NewListNull(moveObjectType)
+	data.Placements = basetypes.NewMapNull(types.Int64Type)
+	data.Utilization = basetypes.NewListNull(types.Int64Type)
+	data.FreeCapacity = basetypes.NewListNull(types.Int64Type)
+	data.ReservedCapacity = basetypes.NewListNull(types.Int64Type)
+	data.Chunks = basetypes.NewListNull(chunksType)
+	data.BucketStatus = basetypes.NewListNull(types.StringType)
+	data.UnplacedItems = basetypes.NewMapNull(itemObjectType)
+	data.BucketPayloads = basetypes.NewDynamicNull()
+	data.Offsets = basetypes.NewMapNull(types.Int64Type)
+	data.Fragmentation = basetypes.NewListNull(types.Float64Type)
+	bucketCount := int(data.MaximumBuckets.ValueInt64())
+	// Buckets past maximum_buckets are only kept until their items are evacuated
+	allBucketCount := bucketCount
+	if state != nil && !state.Buckets.IsUnknown() {
+		allBucketCount = max(bucketCount, len(state.Buckets.Elements()))
+	}
+	capacities := make([]int64, allBucketCount)
+	allBuckets := make([]map[string]BucketItem, allBucketCount)
+	for idx := 0; idx < allBucketCount; idx++ {
+		allBuckets[idx] = make(map[string]BucketItem, 0)
+		capacities[idx] = 0
+	}
+	activeCapacities := capacities[:bucketCount]
+	if data.MinimumBuckets.ValueInt64() > data.MaximumBuckets.ValueInt64() {
+		diagnostics.AddAttributeError(path.Root("minimum_buckets"), fmt.Sprintf("minimum buckets (%d) exceeds maximum buckets (%d)", data.MinimumBuckets.ValueInt64(), bucketCount), "")
+		return nil
+	}
+	bucketCapacity := data.BucketCapacity.ValueInt64()
+	targetCapacity := bucketCapacity
+	if !data.BucketCapacity.IsUnknown() && !data.BucketCapacity.IsNull() {
+		if data.TargetCapacity.ValueInt64() > 0 {
+			targetCapacity = data.TargetCapacity.ValueInt64()
+		}
+	}
+	if targetCapacity > bucketCapacity {
+		// Items over bucket_capacity would be moved out again by the next plan
+		diagnostics.AddAttributeError(path.Root("target_capacity"), fmt.Sprintf("target capacity (%d) exceeds bucket capacity (%d)", targetCapacity, bucketCapacity), "")
+		return nil
+	}
+
+	ratios := make([]float64, allBucketCount)
+	for idx := range ratios {
+		ratios[idx] = 1
+		if !data.OvercommitRatio.IsNull() {
+			ratios[idx] = data.OvercommitRatio.ValueFloat64()
+		}
+	}
+	for idx, v := range data.BucketOvercommit.Elements() {
+		if ratio, ok := v.(basetypes.Float64Value); ok && !ratio.IsNull() {
+			if idx >= bucketCount {
+				diagnostics.AddAttributeError(path.Root("bucket_overcommit_ratios"), fmt.Sprintf("overcommit ratio for bucket %d that does not exist", idx), fmt.Sprintf("maximum buckets: %d", bucketCount))
+				return nil
+			}
+			ratios[idx] = ratio.ValueFloat64()
+		}
+	}
+	bucketLabels := make([]map[string]string, allBucketCount)
+	for idx, v := range data.BucketLabels.Elements() {
+		if idx >= bucketCount {
+			diagnostics.AddAttributeError(path.Root("bucket_labels"), fmt.Sprintf("labels for bucket %d that does not exist", idx), fmt.Sprintf("maximum buckets: %d", bucketCount))
+			return nil
+		}
+		bucketLabels[idx] = stringMap(v)
+	}
+	// Effective capacities used for placing the items
+	bucketLimits := overcommitLimits(bucketCapacity, ratios)
+	targetLimits := overcommitLimits(targetCapacity, ratios)
+
+	maxItems := int(data.MaxItemsPerBucket.ValueInt64())
+	slotsPerBucket := data.SlotsPerBucket.ValueInt64()
+	if slotsPerBucket > 0 && (maxItems == 0 || int(slotsPerBucket) < maxItems) {
+		// There can't be more items than slots in a bucket
+		maxItems = int(slotsPerBucket)
+	}
+	moveBudget := -1
+	if !data.MaxMovesPerApply.IsNull() {
+		moveBudget = int(data.MaxMovesPerApply.ValueInt64())
+	}
+
+	draining := make(map[int]bool, 0)
+	for _, v := range data.DrainingBuckets.Elements() {
+		if bidx, ok := v.(basetypes.Int64Value); ok && !bidx.IsNull() {
+			if bidx.ValueInt64() >= int64(bucketCount) {
+				diagnostics.AddAttributeError(path.Root("draining_buckets"), fmt.Sprintf("draining bucket %d does not exist", bidx.ValueInt64()), fmt.Sprintf("maximum buckets: %d", bucketCount))
+				return nil
+			}
+			draining[int(bidx.ValueInt64())] = true
+		}
+	}
+
+	allocate := data.AllocateOffsets.ValueBool()
+	// Offsets from the state are only kept while the item stays in the same bucket
+	stateOffsets := make(map[string]int64, 0)
+	offsetBuckets := make(map[string]int, 0)
+	grown := make(map[string]bool, 0)
+	stateSlots := make(map[string]int64, 0)
+	fixedOffsets := func(idx int, bucket map[string]BucketItem) map[string]int64 {
+		fixed := make(map[string]int64, 0)
+		for k := range bucket {
+			if offset, ok := stateOffsets[k]; ok && offsetBuckets[k] == idx {
+				fixed[k] = offset
+			}
+		}
+		return fixed
+	}
+	// offsetsFit checks that the items have contiguous ranges in the bucket
+	offsetsFit := func(idx int, bucket map[string]BucketItem) bool {
+		if !allocate {
+			return true
+		}
+		_, unallocated := allocateOffsets(bucket, fixedOffsets(idx, bucket), grown, bucketLimits[idx])
+		return len(unallocated) == 0
+	}
+
+	// canPlace checks the constraints besides weight for adding the unit to a bucket
+	canPlace := func(idx int, unit map[string]BucketItem) bool {
+		if draining[idx] {
+			return false
+		}
+		if maxItems > 0 && len(allBuckets[idx])+len(unit) > maxItems {
+			return false
+		}
+		if antiAffinityConflict(allBuckets[idx], unit) {
+			return false
+		}
+		if len(missingLabels(bucketLabels[idx], unit, false)) > 0 {
+			return false
+		}
+		if allocate {
+			bucket := maps.Clone(allBuckets[idx])
+			maps.Copy(bucket, unit)
+			return offsetsFit(idx, bucket)
+		}
+		return true
+	}
+	// findBucket returns the first bucket with room for the unit, trying the buckets with the
+	// preferred labels of its items first
+	findBucket := func(capacities *[]int64, unitWeight int64, limits []int64, unit map[string]BucketItem, eligible func(int) bool) *int {
+		if hasPreferences(unit) {
+			preferred := findCapacity(capacities, unitWeight, limits, func(idx int) bool {
+				return eligible(idx) && len(missingLabels(bucketLabels[idx], unit, true)) == 0
+			})
+			if preferred != nil {
+				return preferred
+			}
+		}
+		return findCapacity(capacities, unitWeight, limits, eligible)
+	}
+	// explain describes why the units can't be placed in the buckets
+	explain := placementView{
+		buckets:        allBuckets,
+		capacities:     activeCapacities,
+		labels:         bucketLabels,
+		draining:       draining,
+		ratios:         ratios,
+		maxItems:       maxItems,
+		allocate:       allocate,
+		bucketCapacity: bucketCapacity,
+		newRatio:       1.0,
+	}
+	if !data.OvercommitRatio.IsNull() {
+		explain.newRatio = data.OvercommitRatio.ValueFloat64()
+	}
+	// bucketDetails describes the state of the buckets for diagnostics
+	bucketDetails := func() string {
+		details := fmt.Sprintf("bucket capacities: %+v", activeCapacities)
+		if maxItems > 0 {
+			counts := make([]int, 0, len(activeCapacities))
+			for idx := range activeCapacities {
+				counts = append(counts, len(allBuckets[idx]))
+			}
+			details += fmt.Sprintf(", bucket items: %+v", counts)
+		}
+		return details
+	}
+
+	configItems := make(map[string]BucketItem, 0)
+	// Divisible items are split into chunks after the other items are placed
+	divisibleItems := make(map[string]BucketItem, 0)
+	itemElements := data.Items.Elements()
+	for _, k := range slices.Sorted(maps.Keys(itemElements)) {
+		v := itemElements[k]
+		item, ok := parseItem(v)
+		if !ok {
+			continue
+		}
+		replicas, ok := itemReplicas(v)
+		if item.Divisible {
+			if ok || item.AffinityGroup != "" || item.AntiAffinityGroup != "" || item.ReservedWeight > 0 {
+				diagnostics.AddAttributeError(path.Root("items").AtMapKey(k).AtName("divisible"), fmt.Sprintf("divisible items can't have affinity_group, anti_affinity_group, reserved_weight or replicas: %s", k), "")
+				return nil
+			}
+			divisibleItems[k] = item
+			continue
+		}
+		if !ok {
+			configItems[k] = item
+			continue
+		}
+		if item.AffinityGroup != "" {
+			diagnostics.AddAttributeError(path.Root("items").AtMapKey(k).AtName("replicas"), fmt.Sprintf("replicas can't be placed in the same bucket as their affinity group: %s", k), "")
+			return nil
+		}
+		item.ReplicaOf = k
+		for idx := range replicas {
+			rk := replicaKey(k, idx)
+			if _, ok := itemElements[rk]; ok {
+				diagnostics.AddAttributeError(path.Root("items").AtMapKey(k).AtName("replicas"), fmt.Sprintf("replica key already used by another item: %s", rk), "")
+				return nil
+			}
+			configItems[rk] = item
+		}
+	}
+
+	// Items in the same affinity group can't also be kept apart
+	antiAffinityGroups := make(map[string]map[string]string, 0)
+	for _, k := range slices.Sorted(maps.Keys(configItems)) {
+		item := configItems[k]
+		if item.AffinityGroup == "" || item.AntiAffinityGroup == "" {
+			continue
+		}
+		if _, ok := antiAffinityGroups[item.AffinityGroup]; !ok {
+			antiAffinityGroups[item.AffinityGroup] = make(map[string]string, 0)
+		}
+		if other, ok := antiAffinityGroups[item.AffinityGroup][item.AntiAffinityGroup]; ok {
+			diagnostics.AddAttributeError(weightPath(k, item), fmt.Sprintf("unable to place: %s and %s (same affinity group %q and anti-affinity group %q)", other, k, item.AffinityGroup, item.AntiAffinityGroup), "")
+			return nil
+		}
+		antiAffinityGroups[item.AffinityGroup][item.AntiAffinityGroup] = k
+	}
+
+	payloads, ok := payloadElements(data.Payloads)
+	if !ok {
+		diagnostics.AddAttributeError(path.Root("payloads"), "payloads must be an object or a map keyed by the item key", "")
+		return nil
+	}
+	for _, k := range slices.Sorted(maps.Keys(payloads)) {
+		if _, ok := itemElements[k]; !ok {
+			diagnostics.AddAttributeError(path.Root("payloads"), fmt.Sprintf("payload for an unknown item: %s", k), "")
+			return nil
+		}
+		if _, ok := divisibleItems[k]; ok {
+			// Chunks of an item are spread over several buckets
+			diagnostics.AddAttributeError(path.Root("payloads"), fmt.Sprintf("payload for a divisible item: %s", k), "divisible items are split into chunks and have no bucket to carry the payload into")
+			return nil
+		}
+	}
+
+	keysInBuckets := make(map[string]int, 0)
+
+	// An imported layout has no configuration in the state yet, it is adopted as is
+	imported := state != nil && state.MaximumBuckets.IsNull()
+
+	// Fill buckets from TF data
+	if state != nil && !state.Buckets.IsUnknown() {
+		// The state may not have been refreshed, items are only ever loaded into one bucket
+		stateBuckets := checkDuplicates(state.Buckets, data.RepairState.ValueBool(), diagnostics)
+		if diagnostics.HasError() {
+			return nil
+		}
+		for bidx, bucket := range stateBuckets.Elements() {
+			if bucketItems, ok := bucket.(basetypes.MapValue); ok {
+				for k, v := range bucketItems.Elements() {
+					if _, ok := configItems[k]; imported && !ok {
+						diagnostics.AddAttributeError(path.Root("buckets").AtListIndex(bidx).AtMapKey(k), fmt.Sprintf("imported item is not in the configuration: %s", k), "")
+						return nil
+					}
+					if item, ok := parseItem(v); ok {
+						// Placement constraints always come from the configuration
+						item.AffinityGroup = configItems[k].AffinityGroup
+						item.AntiAffinityGroup = configItems[k].AntiAffinityGroup
+						item.Priority = configItems[k].Priority
+						item.ReservedWeight = configItems[k].ReservedWeight
+						item.ReplicaOf = configItems[k].ReplicaOf
+						item.RequiredLabels = configItems[k].RequiredLabels
+						item.PreferredLabels = configItems[k].PreferredLabels
+						allBuckets[bidx][k] = item
+						capacities[bidx] += item.footprint()
+						keysInBuckets[k] = bidx
+						offsetBuckets[k] = bidx
+						if slot, ok := itemSlot(v); ok && slot < slotsPerBucket {
+							stateSlots[k] = slot
+						}
+					}
+				}
+			}
+		}
+		for k, v := range state.Offsets.Elements() {
+			if offset, ok := v.(basetypes.Int64Value); ok && !offset.IsNull() && !offset.IsUnknown() {
+				stateOffsets[k] = offset.ValueInt64()
+			}
+		}
+	}
+	previousBuckets := maps.Clone(keysInBuckets)
+
+	// Remove items, before anything else is moved
+	for bidx, bucket := range allBuckets {
+		for k, v := range bucket {
+			if _, ok := configItems[k]; !ok {
+				capacities[bidx] -= v.footprint()
+				delete(allBuckets[bidx], k)
+				delete(keysInBuckets, k)
+			}
+		}
+	}
+
+	// Remap the buckets in the state when the trigger changes, an imported layout is adopted first
+	var remapped *bucketRemap
+	if trigger := remapTrigger(data.BucketRemap); state != nil && !imported && !trigger.IsNull() && !trigger.IsUnknown() && !trigger.Equal(remapTrigger(state.BucketRemap)) {
+		if !data.MoveItems.ValueBool() {
+			diagnostics.AddError("unable to remap buckets without moving items", "set move_items to true to remap")
+			return nil
+		}
+		rule, err := parseRemap(data.BucketRemap)
+		if err == nil {
+			err = rule.validate(len(state.Buckets.Elements()), bucketCount)
+		}
+		if err != nil {
+			diagnostics.AddAttributeError(path.Root("bucket_remap"), "invalid bucket remap", err.Error())
+			return nil
+		}
+		overflows := make([]string, 0)
+		for bidx, bucket := range remapBuckets(allBuckets, rule, bucketLimits) {
+			allBuckets[bidx] = bucket
+			capacities[bidx] = 0
+			for k, v := range bucket {
+				capacities[bidx] += v.footprint()
+				keysInBuckets[k] = bidx
+			}
+			if capacities[bidx] > bucketLimits[bidx] {
+				overflows = append(overflows, fmt.Sprintf("bucket %d: %d of %d", bidx, capacities[bidx], bucketLimits[bidx]))
+			}
+		}
+		if len(overflows) > 0 {
+			diagnostics.AddAttributeWarning(path.Root("bucket_remap"), fmt.Sprintf("%d bucket(s) are over capacity after the remap", len(overflows)), "Items are moved out of the buckets that are over capacity.\n"+strings.Join(overflows, "\n"))
+		}
+		remapped = &rule
+	}
+
+	// Chunks from the state stay in place, shrunk to the new weight and to the room left by the
+	// other items in their bucket, and take up their capacity while the other items are placed
+	previousChunks := make(map[string]map[int]int64, 0)
+	if state != nil {
+		previousChunks = stateChunks(state.Chunks)
+	}
+	itemsRoom := make([]int64, bucketCount)
+	for bidx := range itemsRoom {
+		itemsRoom[bidx] = bucketLimits[bidx]
+		for _, v := range allBuckets[bidx] {
+			itemsRoom[bidx] -= v.footprint()
+		}
+	}
+	keptChunks := make(map[string][]int64, len(divisibleItems))
+	for _, k := range slices.Sorted(maps.Keys(divisibleItems)) {
+		item := divisibleItems[k]
+		previous := make([]int64, bucketCount)
+		for bidx, size := range previousChunks[k] {
+			if remapped != nil {
+				bidx = remapped.targets(bidx)[0]
+			}
+			if bidx < bucketCount {
+				previous[bidx] += size
+			}
+		}
+		room := make([]int64, bucketCount)
+		for bidx := range room {
+			room[bidx] = max(itemsRoom[bidx], 0)
+		}
+		// Chunks are moved out of evacuated buckets within the move budget, like the other items
+		evacuated := make(map[int]bool, 0)
+		for _, bidx := range slices.Sorted(maps.Keys(draining)) {
+			if !data.Evacuate.ValueBool() || previous[bidx] == 0 || moveBudget == 0 {
+				continue
+			}
+			evacuated[bidx] = true
+			if moveBudget > 0 {
+				moveBudget--
+			}
+		}
+		// and out of buckets without the required labels
+		kept := keepChunks(item.Weight, item.MinChunk, previous, room, func(idx int) bool {
+			return !evacuated[idx] && len(missingLabels(bucketLabels[idx], map[string]BucketItem{k: item}, false)) == 0
+		})
+		for bidx, size := range kept {
+			itemsRoom[bidx] -= size
+			capacities[bidx] += size
+		}
+		keptChunks[k] = kept
+	}
+	previouslyUnplaced := make(map[string]bool, 0)
+	if state != nil && !state.UnplacedItems.IsUnknown() {
+		for k := range state.UnplacedItems.Elements() {
+			previouslyUnplaced[k] = true
+		}
+	}
+	unplaced := make(map[string]BucketItem, 0)
+	unplacedReasons := make(map[string]string, 0)
+	// overflowUnit takes the unit out of the buckets when items that don't fit are only reported
+	overflowUnit := func(unit map[string]BucketItem, from int, reason string) bool {
+		if data.OnOverflow.ValueString() != overflowReport {
+			return false
+		}
+		for k, v := range unit {
+			if from >= 0 {
+				delete(allBuckets[from], k)
+				capacities[from] -= v.footprint()
+				delete(keysInBuckets, k)
+			}
+			// Reported with the weight in the configuration
+			unplaced[k] = configItems[k]
+			unplacedReasons[k] = reason
+		}
+		return true
+	}
+
+	// relocateUnits moves the picked units out of the bucket, keeping the moves only if all the
+	// units found a new bucket and keep is set
+	relocateUnits := func(bidx int, units []map[string]BucketItem, weights []int64, picked []int, keep bool) bool {
+		moved := make([]int, 0, len(picked))
+		for _, uidx := range picked {
+			newBucket := findBucket(&activeCapacities, weights[uidx], bucketLimits, units[uidx], func(idx int) bool {
+				return idx != bidx && canPlace(idx, units[uidx])
+			})
+			if newBucket == nil {
+				break
+			}
+			moveItems(allBuckets, capacities, keysInBuckets, units[uidx], bidx, *newBucket)
+			moved = append(moved, *newBucket)
+		}
+		placed := len(moved) == len(picked)
+		if !placed || !keep {
+			for idx := len(moved) - 1; idx >= 0; idx-- {
+				moveItems(allBuckets, capacities, keysInBuckets, units[picked[idx]], moved[idx], bidx)
+			}
+		}
+		return placed
+	}
+
+	// Sort keys to make more predictable results
+	keysDefined := make([]string, 0)
+	for k := range configItems {
+		keysDefined = append(keysDefined, k)
+	}
+	sort.Strings(keysDefined)
+
+	// Adjust bucket capacities to the new weights before any item is moved
+	previousWeights := make(map[string]int64, 0)
+	for _, k := range keysDefined {
+		keyInBucket, ok := keysInBuckets[k]
+		if !ok {
+			continue
+		}
+		newItem := configItems[k]
+		// Growing within the reserved weight doesn't change the space taken in the bucket
+		previousFootprint := allBuckets[keyInBucket][k].footprint()
+		newFootprint := newItem.footprint()
+
+		previousWeights[k] = allBuckets[keyInBucket][k].Weight
+		allBuckets[keyInBucket][k] = newItem
+		capacities[keyInBucket] += newFootprint - previousFootprint
+		grown[k] = newFootprint > previousFootprint
+	}
+
+	newItems := make(map[string]BucketItem, 0)
+	for _, k := range keysDefined {
+		newItem := configItems[k]
+		if _, ok := unplaced[k]; ok {
+			// Already reported together with its affinity group
+			continue
+		}
+		if _, ok := keysInBuckets[k]; !ok {
+			newItems[k] = newItem
+			continue
+		}
+
+		keyInBucket := keysInBuckets[k]
+		previousWeight := previousWeights[k]
+		newWeight := newItem.Weight
+		// Check if new weight would require moving the item to a new bucket
+		if grown[k] && capacities[keyInBucket] > bucketLimits[keyInBucket] {
+			if !data.MoveCost.IsNull() && data.MoveItems.ValueBool() {
+				// Moving other items out of the bucket may be cheaper than moving the grown item
+				units, weights := bucketUnits(allBuckets[keyInBucket])
+				overflow := capacities[keyInBucket] - bucketLimits[keyInBucket]
+				picked, truncated := cheapestMoves(units, weights, overflow, data.MoveCost.ValueString(), func(picked []int) bool {
+					return relocateUnits(keyInBucket, units, weights, picked, false)
+				})
+				if truncated {
+					diagnostics.AddWarning(fmt.Sprintf("search for the cheapest moves out of bucket %d stopped after %d candidates", keyInBucket, moveSearchLimit), fmt.Sprintf("growing item: %s", k))
+				}
+				if picked != nil {
+					relocateUnits(keyInBucket, units, weights, picked, true)
+					continue
+				}
+			}
+			// Items in the same affinity group are moved together
+			unit, unitWeight := affinityUnit(allBuckets[keyInBucket], k)
+			newBucket := findBucket(&activeCapacities, unitWeight, bucketLimits, unit, func(idx int) bool {
+				return canPlace(idx, unit)
+			})
+			if newBucket == nil {
+				reason := explain.limitReached(unit, unitWeight, bucketLimits)
+				if overflowUnit(unit, keyInBucket, reason) {
+					continue
+				}
+				diagnostics.AddAttributeError(weightPath(k, newItem), fmt.Sprintf("unable to find bucket capacity for: %s (previous weight %d, new weight %d, %s)", k, previousWeight, newWeight, reason), explain.placementDetails(unit, unitWeight, bucketLimits, "bucket_capacity", bucketCapacity, -1))
+				return nil
+			}
+			if !data.MoveItems.ValueBool() {
+				diagnostics.AddAttributeError(weightPath(k, newItem), fmt.Sprintf("unable to find bucket capacity for moving item: %s (previous weight %d, new weight %d)", k, previousWeight, newWeight), bucketDetails())
+				return nil
+			}
+			moveItems(allBuckets, capacities, keysInBuckets, unit, keyInBucket, *newBucket)
+		}
+	}
+
+	// Move items out of buckets that are over capacity after bucket_capacity or
+	// max_items_per_bucket was lowered
+	// evictUnit moves the unit out of a bucket exceeding its limits
+	evictUnit := func(bidx int, unit map[string]BucketItem) bool {
+		unitKeys := slices.Sorted(maps.Keys(unit))
+		unitWeight := int64(0)
+		for _, v := range unit {
+			unitWeight += v.footprint()
+		}
+		if !data.MoveItems.ValueBool() {
+			diagnostics.AddAttributeError(weightPath(unitKeys[0], unit[unitKeys[0]]), fmt.Sprintf("unable to find bucket capacity for moving item: %s (bucket %d exceeds its limits)", strings.Join(unitKeys, ", "), bidx), bucketDetails())
+			return false
+		}
+		newBucket := findBucket(&activeCapacities, unitWeight, bucketLimits, unit, func(idx int) bool {
+			return idx != bidx && canPlace(idx, unit)
+		})
+		if newBucket == nil {
+			reason := explain.limitReached(unit, unitWeight, bucketLimits)
+			if overflowUnit(unit, bidx, reason) {
+				return true
+			}
+			diagnostics.AddAttributeError(weightPath(unitKeys[0], unit[unitKeys[0]]), fmt.Sprintf("unable to find bucket capacity for: %s (weight %d, bucket %d exceeds its limits, %s)", strings.Join(unitKeys, ", "), unitWeight, bidx, reason), explain.placementDetails(unit, unitWeight, bucketLimits, "bucket_capacity", bucketCapacity, bidx))
+			return false
+		}
+		moveItems(allBuckets, capacities, keysInBuckets, unit, bidx, *newBucket)
+		return true
+	}
+	for bidx := 0; bidx < bucketCount; bidx++ {
+		// Items whose required labels the bucket doesn't have anymore
+		for _, k := range slices.Sorted(maps.Keys(allBuckets[bidx])) {
+			v, ok := allBuckets[bidx][k]
+			if !ok || len(missingLabels(bucketLabels[bidx], map[string]BucketItem{k: v}, false)) == 0 {
+				continue
+			}
+			unit, _ := affinityUnit(allBuckets[bidx], k)
+			if !evictUnit(bidx, unit) {
+				return nil
+			}
+		}
+		excess := 0
+		if maxItems > 0 {
+			excess = len(allBuckets[bidx]) - maxItems
+		}
+		if capacities[bidx] > bucketLimits[bidx] || excess > 0 {
+			for _, unit := range evictionUnits(allBuckets[bidx], capacities[bidx]-bucketLimits[bidx], excess) {
+				if !evictUnit(bidx, unit) {
+					return nil
+				}
+			}
+		}
+		// Items that grew may not have a contiguous range left in the bucket
+		for allocate {
+			_, unallocated := allocateOffsets(allBuckets[bidx], fixedOffsets(bidx, allBuckets[bidx]), grown, bucketLimits[bidx])
+			if len(unallocated) == 0 {
+				break
+			}
+			unit, _ := affinityUnit(allBuckets[bidx], unallocated[0])
+			if !evictUnit(bidx, unit) {
+				return nil
+			}
+		}
+	}
+
+	// Evacuate items from buckets that are dropped when maximum_buckets shrinks
+	for bidx := bucketCount; bidx < allBucketCount; bidx++ {
+		for _, k := range slices.Sorted(maps.Keys(allBuckets[bidx])) {
+			if _, ok := allBuckets[bidx][k]; !ok {
+				// Already moved together with its affinity group
+				continue
+			}
+			if !data.MoveItems.ValueBool() {
+				diagnostics.AddAttributeError(weightPath(k, allBuckets[bidx][k]), fmt.Sprintf("unable to find bucket capacity for moving item: %s (bucket %d is removed)", k, bidx), fmt.Sprintf("maximum buckets: %d", bucketCount))
+				return nil
+			}
+			unit, unitWeight := affinityUnit(allBuckets[bidx], k)
+			newBucket := findBucket(&activeCapacities, unitWeight, bucketLimits, unit, func(idx int) bool {
+				return canPlace(idx, unit)
+			})
+			if newBucket == nil {
+				reason := explain.limitReached(unit, unitWeight, bucketLimits)
+				if overflowUnit(unit, bidx, reason) {
+					continue
+				}
+				diagnostics.AddAttributeError(weightPath(k, unit[k]), fmt.Sprintf("unable to find bucket capacity for: %s (weight %d, bucket %d is removed, %s)", k, unitWeight, bidx, reason), explain.placementDetails(unit, unitWeight, bucketLimits, "bucket_capacity", bucketCapacity, -1))
+				return nil
+			}
+			moveItems(allBuckets, capacities, keysInBuckets, unit, bidx, *newBucket)
+		}
+	}
+	allBuckets = allBuckets[:bucketCount]
+	capacities = activeCapacities
+
+	// Move items out of draining buckets, within the move budget
+	if data.Evacuate.ValueBool() {
+		if !data.MoveItems.ValueBool() {
+			diagnostics.AddError("unable to evacuate draining buckets without moving items", "set move_items to true to evacuate")
+			return nil
+		}
+		for _, bidx := range slices.Sorted(maps.Keys(draining)) {
+			units, weights := bucketUnits(allBuckets[bidx])
+			for uidx, unit := range units {
+				if moveBudget >= 0 && len(unit) > moveBudget {
+					continue
+				}
+				newBucket := findBucket(&capacities, weights[uidx], bucketLimits, unit, func(idx int) bool {
+					return canPlace(idx, unit)
+				})
+				if newBucket == nil {
+					unitKeys := slices.Sorted(maps.Keys(unit))
+					diagnostics.AddWarning(fmt.Sprintf("unable to evacuate: %s (weight %d, %s)", strings.Join(unitKeys, ", "), weights[uidx], explain.limitReached(unit, weights[uidx], bucketLimits)), explain.placementDetails(unit, weights[uidx], bucketLimits, "bucket_capacity", bucketCapacity, bidx))
+					continue
+				}
+				moveItems(allBuckets, capacities, keysInBuckets, unit, bidx, *newBucket)
+				if moveBudget >= 0 {
+					moveBudget -= len(unit)
+				}
+			}
+		}
+	}
+
+	// Sort keys to make more predictable results, items of higher priority are placed first
+	newItemsKeys := make([]string, 0)
+	for k := range newItems {
+		newItemsKeys = append(newItemsKeys, k)
+	}
+	sort.Strings(newItemsKeys)
+	slices.SortStableFunc(newItemsKeys, func(a, b string) int {
+		return cmp.Compare(newItems[b].Priority, newItems[a].Priority)
+	})
+	newGroupKeys := make(map[string][]string, 0)
+	for _, k := range newItemsKeys {
+		if group := newItems[k].AffinityGroup; group != "" {
+			newGroupKeys[group] = append(newGroupKeys[group], k)
+		}
+	}
+	groupMembers := make(map[string][]string, 0)
+	for _, k := range keysDefined {
+		if group := configItems[k].AffinityGroup; group != "" {
+			groupMembers[group] = append(groupMembers[group], k)
+		}
+	}
+	capacityIndex := newCapacityTree(capacities, targetLimits[:len(capacities)])
+
+	// Units evicted while making room that didn't fit anywhere else
+	evictedUnits := make([]map[string]BucketItem, 0)
+	// Add new items in buckets with capacity
+	for _, k := range newItemsKeys {
+		v := newItems[k]
+		if _, ok := keysInBuckets[k]; ok {
+			// Already placed together with its affinity group
+			continue
+		}
+		if _, ok := unplaced[k]; ok {
+			continue
+		}
+
+		unit := map[string]BucketItem{k: v}
+		unitWeight := v.footprint()
+		pinnedBucket := -1
+		if v.AffinityGroup != "" {
+			pinnedBucket = affinityBucket(keysInBuckets, groupMembers[v.AffinityGroup])
+			if pinnedBucket < 0 {
+				// First items of an affinity group are placed together
+				for _, nk := range newGroupKeys[v.AffinityGroup] {
+					if nk != k {
+						unit[nk] = newItems[nk]
+						unitWeight += newItems[nk].footprint()
+					}
+				}
+			}
+		}
+
+		var targetBucket *int
+		if pinnedBucket >= 0 {
+			if capacities[pinnedBucket]+unitWeight <= targetLimits[pinnedBucket] && canPlace(pinnedBucket, unit) {
+				targetBucket = &pinnedBucket
+			}
+		} else {
+			if hasPreferences(unit) {
+				targetBucket = capacityIndex.findCapacity(unitWeight, func(idx int) bool {
+					return canPlace(idx, unit) && len(missingLabels(bucketLabels[idx], unit, true)) == 0
+				})
+			}
+			if targetBucket == nil {
+				targetBucket = capacityIndex.findCapacity(unitWeight, func(idx int) bool {
+					return canPlace(idx, unit)
+				})
+			}
+		}
+		if targetBucket == nil {
+			// Make room by evicting items of lower priority, preferring the bucket where the
+			// evicted items have the lowest priority and the least weight
+			var evicted []map[string]BucketItem
+			for idx := range capacities {
+				if draining[idx] || (pinnedBucket >= 0 && idx != pinnedBucket) {
+					continue
+				}
+				if len(missingLabels(bucketLabels[idx], unit, false)) > 0 {
+					continue
+				}
+				candidate, ok := priorityEvictions(allBuckets[idx], capacities[idx], unit, unitWeight, targetLimits[idx], maxItems)
+				if !ok {
+					continue
+				}
+				if allocate {
+					bucket := maps.Clone(allBuckets[idx])
+					for _, evictedUnit := range candidate {
+						for ek := range evictedUnit {
+							delete(bucket, ek)
+						}
+					}
+					maps.Copy(bucket, unit)
+					if !offsetsFit(idx, bucket) {
+						continue
+					}
+				}
+				if targetBucket == nil || evictionCost(candidate).less(evictionCost(evicted)) {
+					targetBucket = &idx
+					evicted = candidate
+				}
+			}
+			for _, evictedUnit := range evicted {
+				for ek, ev := range evictedUnit {
+					delete(allBuckets[*targetBucket], ek)
+					capacities[*targetBucket] -= ev.footprint()
+					delete(keysInBuckets, ek)
+				}
+			}
+			if targetBucket != nil {
+				for uk, uv := range unit {
+					allBuckets[*targetBucket][uk] = uv
+					keysInBuckets[uk] = *targetBucket
+				}
+				capacities[*targetBucket] += unitWeight
+				capacityIndex.update(*targetBucket)
+			}
+			// Evicted items are moved to another bucket if there is room
+			for _, evictedUnit := range evicted {
+				evictedWeight := int64(0)
+				for _, ev := range evictedUnit {
+					evictedWeight += ev.footprint()
+				}
+				var newBucket *int
+				if data.MoveItems.ValueBool() {
+					newBucket = findBucket(&capacities, evictedWeight, bucketLimits, evictedUnit, func(idx int) bool {
+						return idx != *targetBucket && canPlace(idx, evictedUnit)
+					})
+				}
+				if newBucket == nil {
+					evictedUnits = append(evictedUnits, evictedUnit)
+				}
+				for ek, ev := range evictedUnit {
+					if newBucket == nil {
+						unplaced[ek] = ev
+						unplacedReasons[ek] = fmt.Sprintf("evicted by %s", k)
+						continue
+					}
+					allBuckets[*newBucket][ek] = ev
+					keysInBuckets[ek] = *newBucket
+				}
+				if newBucket != nil {
+					capacities[*newBucket] += evictedWeight
+					capacityIndex.update(*newBucket)
+				}
+			}
+			if targetBucket != nil {
+				continue
+			}
+		}
+		if targetBucket == nil {
+			// Items that were evicted before stay unplaced until there is room for them
+			reason := explain.limitReached(unit, unitWeight, targetLimits)
+			if pinnedBucket >= 0 {
+				reason = fmt.Sprintf("affinity group %q in bucket %d", v.AffinityGroup, pinnedBucket)
+			}
+			if previouslyUnplaced[k] {
+				for uk, uv := range unit {
+					unplaced[uk] = uv
+					unplacedReasons[uk] = reason
+				}
+				continue
+			}
+			if overflowUnit(unit, -1, reason) {
+				continue
+			}
+			capacityAttr := "bucket_capacity"
+			if targetCapacity != bucketCapacity {
+				capacityAttr = "target_capacity"
+			}
+			diagnostics.AddAttributeError(weightPath(k, v), fmt.Sprintf("unable to find bucket capacity for: %s (weight %d, %s)", k, v.Weight, reason), explain.placementDetails(unit, unitWeight, targetLimits, capacityAttr, targetCapacity, -1))
+			return nil
+		}
+		for uk, uv := range unit {
+			allBuckets[*targetBucket][uk] = uv
+			keysInBuckets[uk] = *targetBucket
+		}
+		capacities[*targetBucket] += unitWeight
+		capacityIndex.update(*targetBucket)
+	}
+
+	// Later evictions may have freed enough room for the units evicted earlier, which are placed
+	// again in priority order so that the next plan doesn't move more items
+	slices.SortStableFunc(evictedUnits, func(a, b map[string]BucketItem) int {
+		return cmp.Compare(unitPriority(b), unitPriority(a))
+	})
+	for _, unit := range evictedUnits {
+		if !data.MoveItems.ValueBool() {
+			break
+		}
+		unitWeight := int64(0)
+		for _, uv := range unit {
+			unitWeight += uv.footprint()
+		}
+		newBucket := findBucket(&capacities, unitWeight, bucketLimits, unit, func(idx int) bool {
+			return canPlace(idx, unit)
+		})
+		if newBucket == nil {
+			continue
+		}
+		for uk, uv := range unit {
+			delete(unplaced, uk)
+			delete(unplacedReasons, uk)
+			allBuckets[*newBucket][uk] = uv
+			keysInBuckets[uk] = *newBucket
+		}
+		capacities[*newBucket] += unitWeight
+		capacityIndex.update(*newBucket)
+	}
+
+	// Rebalance the buckets when the trigger changes, an imported layout is adopted first
+	if state != nil && !imported && !data.RebalanceTrigger.IsNull() && !data.RebalanceTrigger.Equal(state.RebalanceTrigger) {
+		if !data.MoveItems.ValueBool() {
+			diagnostics.AddError("unable to rebalance buckets without moving items", "set move_items to true to rebalance")
+			return nil
+		}
+		rebalanceBuckets(allBuckets, capacities, keysInBuckets, data.RebalanceStrategy.ValueString(), targetLimits[:bucketCount], canPlace, moveBudget)
+	}
+
+	// Fill the remaining capacity with the chunks of the divisible items
+	chunks := make(map[string][]int64, len(divisibleItems))
+	for _, k := range slices.Sorted(maps.Keys(divisibleItems)) {
+		item := divisibleItems[k]
+		room := make([]int64, bucketCount)
+		for bidx := range room {
+			room[bidx] = max(bucketLimits[bidx]-capacities[bidx]+keptChunks[k][bidx], 0)
+		}
+		itemChunks, left := growChunks(item.Weight, item.MinChunk, keptChunks[k], room, func(idx int) bool {
+			return !draining[idx] && len(missingLabels(bucketLabels[idx], map[string]BucketItem{k: item}, false)) == 0
+		})
+		if left > 0 {
+			reason := fmt.Sprintf("%d of weight %d doesn't fit in chunks of at least %d", left, item.Weight, chunkMinimum(item.Weight, item.MinChunk))
+			if data.OnOverflow.ValueString() != overflowReport {
+				diagnostics.AddAttributeError(weightPath(k, item), fmt.Sprintf("unable to find bucket capacity for: %s (%s)", k, reason), bucketDetails())
+				return nil
+			}
+			unplaced[k] = BucketItem{Weight: left, Item: item.Item}
+			unplacedReasons[k] = reason
+		}
+		for bidx, size := range itemChunks {
+			capacities[bidx] += size - keptChunks[k][bidx]
+		}
+		chunks[k] = itemChunks
+	}
+
+	// Only provision the buckets that are in use when a minimum is set
+	if !data.MinimumBuckets.IsNull() {
+		outputCount := int(data.MinimumBuckets.ValueInt64())
+		if state != nil && !state.Buckets.IsUnknown() && !data.ShrinkEmpty.ValueBool() {
+			outputCount = max(outputCount, min(len(state.Buckets.Elements()), bucketCount))
+		}
+		for bidx, items := range allBuckets {
+			if len(items) > 0 {
+				outputCount = max(outputCount, bidx+1)
+			}
+		}
+		for _, itemChunks := range chunks {
+			for bidx, size := range itemChunks {
+				if size > 0 {
+					outputCount = max(outputCount, bidx+1)
+				}
+			}
+		}
+		allBuckets = allBuckets[:outputCount]
+		capacities = capacities[:outputCount]
+	}
+
+	// Reserved weight is reported separately from the weight of the items
+	usedWeights := make([]int64, len(allBuckets))
+	reservedWeights := make([]int64, len(allBuckets))
+	for bidx, items := range allBuckets {
+		for _, v := range items {
+			usedWeights[bidx] += v.Weight
+			reservedWeights[bidx] += v.footprint() - v.Weight
+		}
+	}
+	chunkWeights := make([]int64, len(allBuckets))
+	for _, itemChunks := range chunks {
+		for bidx, size := range itemChunks {
+			if bidx < len(chunkWeights) {
+				chunkWeights[bidx] += size
+				usedWeights[bidx] += size
+			}
+		}
+	}
+
+	// Overcommitted buckets may hold more than their physical capacity
+	overcommitted := make([]string, 0)
+	for bidx, used := range usedWeights {
+		if used > bucketCapacity {
+			overcommitted = append(overcommitted, fmt.Sprintf("bucket %d: %d", bidx, used))
+		}
+	}
+	if len(overcommitted) > 0 {
+		diagnostics.AddWarning(fmt.Sprintf("%d bucket(s) exceed the physical capacity of %d", len(overcommitted), bucketCapacity), strings.Join(overcommitted, "\n"))
+	}
+
+	// Generate output data
+	tfBuckets := make([]attr.Value, 0)
+	for bidx, items := range allBuckets {
+		// Slots are kept while the item stays in the same bucket and freed slots are reused
+		slots := make(map[string]int64, 0)
+		if slotsPerBucket > 0 {
+			bucketSlots := make(map[string]int64, 0)
+			for k := range items {
+				if slot, ok := stateSlots[k]; ok && previousBuckets[k] == bidx {
+					bucketSlots[k] = slot
+				}
+			}
+			_, slots = assignKeys(slices.Collect(maps.Keys(items)), bucketSlots, true, 0, -1)
+		}
+		tfItems := make(map[string]attr.Value, 0)
+		for k, v := range items {
+			slot := types.Int64Null()
+			if assigned, ok := slots[k]; ok {
+				slot = types.Int64Value(assigned)
+			}
+			tfItem := createItem(v.Weight, v.Item, slot, diagnostics)
+			if tfItem == nil {
+				diagnostics.AddError(fmt.Sprintf("failed to create a map item for: %s", k), fmt.Sprintf("item: %s", v.Item))
+				return nil
+			}
+			tfItems[k] = *tfItem
+		}
+		bucketMap, diags := types.MapValue(itemObjectType, tfItems)
+		if diags.HasError() {
+			return nil
+		}
+		diagnostics.Append(diags...)
+
+		tfBuckets = append(tfBuckets, bucketMap)
+	}
+
+	// Set output value
+	bucketsValue, diags := types.ListValue(bucketsType, tfBuckets)
+	if diags.HasError() {
+		diagnostics.AddError("failed to create buckets map for output", "")
+		return nil
+	}
+	data.Buckets = bucketsValue
+	diagnostics.Append(diags...)
+
+	placements := make(map[string]attr.Value, len(keysInBuckets))
+	for bidx, items := range allBuckets {
+		for k := range items {
+			placements[k] = types.Int64Value(int64(bidx))
+		}
+	}
+	utilization := make([]attr.Value, 0, len(capacities))
+	reservedCapacity := make([]attr.Value, 0, len(capacities))
+	freeCapacity := make([]attr.Value, 0, len(capacities))
+	bucketStatus := make([]attr.Value, 0, len(capacities))
+	for bidx, used := range capacities {
+		utilization = append(utilization, types.Int64Value(usedWeights[bidx]))
+		reservedCapacity = append(reservedCapacity, types.Int64Value(reservedWeights[bidx]))
+		freeCapacity = append(freeCapacity, types.Int64Value(bucketCapacity-used))
+		switch {
+		case draining[bidx] && (len(allBuckets[bidx]) > 0 || chunkWeights[bidx] > 0):
+			bucketStatus = append(bucketStatus, types.StringValue("draining"))
+		case draining[bidx]:
+			bucketStatus = append(bucketStatus, types.StringValue("drained"))
+		default:
+			bucketStatus = append(bucketStatus, types.StringValue("active"))
+		}
+	}
+	data.Placements, diags = types.MapValue(types.Int64Type, placements)
+	diagnostics.Append(diags...)
+	data.Utilization, diags = types.ListValue(types.Int64Type, utilization)
+	diagnostics.Append(diags...)
+	data.ReservedCapacity, diags = types.ListValue(types.Int64Type, reservedCapacity)
+	diagnostics.Append(diags...)
+	data.FreeCapacity, diags = types.ListValue(types.Int64Type, freeCapacity)
+	diagnostics.Append(diags...)
+	data.BucketStatus, diags = types.ListValue(types.StringType, bucketStatus)
+	diagnostics.Append(diags...)
+	data.Chunks = createChunks(len(allBuckets), chunks, diagnostics)
+
+	tfUnplaced := make(map[string]attr.Value, len(unplaced))
+	for k, v := range unplaced {
+		tfItem := createItem(v.Weight, v.Item, types.Int64Null(), diagnostics)
+		if tfItem == nil {
+			diagnostics.AddError(fmt.Sprintf("failed to create a map item for: %s", k), fmt.Sprintf("item: %s", v.Item))
+			return nil
+		}
+		tfUnplaced[k] = *tfItem
+	}
+	data.UnplacedItems, diags = types.MapValue(itemObjectType, tfUnplaced)
+	diagnostics.Append(diags...)
+	data.BucketPayloads = createPayloads(allBuckets, payloads, diagnostics)
+
+	if allocate {
+		offsets := make(map[string]attr.Value, len(keysInBuckets))
+		bucketFragmentation := make([]attr.Value, 0, len(allBuckets))
+		for bidx, items := range allBuckets {
+			bucketOffsets, unallocated := allocateOffsets(items, fixedOffsets(bidx, items), grown, bucketLimits[bidx])
+			if len(unallocated) > 0 {
+				diagnostics.AddError(fmt.Sprintf("unable to allocate offsets for: %s (bucket %d)", strings.Join(unallocated, ", "), bidx), bucketDetails())
+				return nil
+			}
+			for k, offset := range bucketOffsets {
+				offsets[k] = types.Int64Value(offset)
+			}
+			bucketFragmentation = append(bucketFragmentation, types.Float64Value(fragmentation(items, bucketOffsets, bucketLimits[bidx])))
+		}
+		data.Offsets, diags = types.MapValue(types.Int64Type, offsets)
+		diagnostics.Append(diags...)
+		data.Fragmentation, diags = types.ListValue(types.Float64Type, bucketFragmentation)
+		diagnostics.Append(diags...)
+	}
+	if len(unplaced) > 0 {
+		reasons := make([]string, 0, len(unplaced))
+		for _, k := range slices.Sorted(maps.Keys(unplaced)) {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", k, unplacedReasons[k]))
+		}
+		diagnostics.AddWarning(fmt.Sprintf("%d item(s) are not placed in any bucket", len(unplaced)), strings.Join(reasons, "\n"))
+	}
+	mismatches := make([]string, 0)
+	for bidx, items := range allBuckets {
+		for _, k := range slices.Sorted(maps.Keys(items)) {
+			if missing := missingLabels(bucketLabels[bidx], map[string]BucketItem{k: items[k]}, true); len(missing) > 0 {
+				mismatches = append(mismatches, fmt.Sprintf("%s: bucket %d lacks %s", k, bidx, strings.Join(missing, ", ")))
+			}
+		}
+	}
+	if len(mismatches) > 0 {
+		diagnostics.AddWarning(fmt.Sprintf("%d item(s) are placed in buckets without their preferred labels", len(mismatches)), strings.Join(mismatches, "\n"))
+	}
+
+	moves := make([]bucketMove, 0)
+	for _, k := range slices.Sorted(maps.Keys(previousBuckets)) {
+		if bidx, ok := keysInBuckets[k]; ok && bidx != previousBuckets[k] && bidx < len(allBuckets) {
+			if _, ok := allBuckets[bidx][k]; ok {
+				moves = append(moves, bucketMove{Key: k, From: previousBuckets[k], To: bidx})
+			}
+		}
+	}
+	if imported && len(moves) > 0 {
+		diagnostics.AddAttributeError(path.Root("buckets"), fmt.Sprintf("imported layout doesn't fit the configuration, %d item(s) would be moved", len(moves)), formatMoves(moves))
+		return nil
+	}
+	data.LastMoves = createMoves(moves, diagnostics)
+	return moves
+}
+
+func (r *PersistentBucketsResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	// Nothing to plan on destroy, and buckets can't be planned while the configuration is not fully known
+	if req.Plan.Raw.IsNull() || !req.Config.Raw.IsFullyKnown() {
+		return
+	}
+
+	var data, state *PersistentBucketsResourceModel
+
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if !req.State.Raw.IsNull() {
+		resp.Diagnostics.Append(req.State.Get(ctx, &state)...)
+	}
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Computed values are only unknown if the resource is going to be updated anyway
+	changed := data.LastMoves.IsUnknown()
+
+	moves := workTheBuckets(data, state, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if len(moves) > 0 {
+		resp.Diagnostics.AddWarning(fmt.Sprintf("%d item(s) will be moved to another bucket", len(moves)), formatMoves(moves))
+	}
+
+	// Keep the moves of the last apply only if nothing is going to change, an apply that changes
+	// any other computed value reports its own (possibly empty) moves
+	if state != nil && !changed {
+		computedMoves := data.LastMoves
+		data.LastMoves = state.LastMoves
+		resp.Diagnostics.Append(resp.Plan.Set(ctx, &data)...)
+		if resp.Diagnostics.HasError() || resp.Plan.Raw.Equal(req.State.Raw) {
+			return
+		}
+		data.LastMoves = computedMoves
+	}
+
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &data)...)
+}
+
+func (r *PersistentBucketsResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var data *PersistentBucketsResourceModel
+
+	// Read Terraform plan data into the model
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	data.Id = types.StringValue("persistent_buckets")
+
+	workTheBuckets(data, nil, &resp.Diagnostics)
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *PersistentBucketsResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var data *PersistentBucketsResourceModel
+
+	// Read Terraform prior state data into the model
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	checkState(data, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Save updated data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
 func (r *PersistentBucketsResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
@@ -407,5 +1979,16 @@ func (r *PersistentBucketsResource) Delete(ctx context.Context, req resource.Del
 }
 
 func (r *PersistentBucketsResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
-	resource.ImportStatePassthroughID(ctx, path.Root("id"), req, resp)
+	layout, err := parseLayout(req.ID)
+	if err != nil {
+		resp.Diagnostics.AddError("unable to import bucket layout", err.Error())
+		return
+	}
+	buckets := layoutBuckets(layout, &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	// The layout is validated against the configuration when planning
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), types.StringValue("persistent_buckets"))...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("buckets"), buckets)...)
 }
@@ -1,9 +1,14 @@
 package provider
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 )
 
@@ -116,6 +121,14 @@ func TestAccPersistentBucketsGrowResource(t *testing.T) {
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-4.weight", "50"),
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-4.item", ""),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.%", "4"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-4", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "utilization.#", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "utilization.0", "85"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "utilization.1", "50"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "free_capacity.0", "15"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "free_capacity.1", "50"),
 				),
 			},
 			{
@@ -185,6 +198,10 @@ func TestAccPersistentBucketsTargetCapacityResource(t *testing.T) {
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-3.item", ""),
 				),
 			},
+			{
+				Config:      strings.Replace(testAccBucketsResourceTargetCapacityUpdateConfig(), "target_capacity = 80", "target_capacity = 120", 1),
+				ExpectError: regexp.MustCompile(`target capacity \(120\) exceeds bucket capacity \(100\)`),
+			},
 		},
 	})
 }
@@ -215,6 +232,7 @@ func TestAccPersistentBucketsMoveItemResource(t *testing.T) {
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-3.weight", "20"),
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-3.item", ""),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "0"),
 				),
 			},
 			{
@@ -233,8 +251,16 @@ func TestAccPersistentBucketsMoveItemResource(t *testing.T) {
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-1.item", "some string data here"),
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-3.weight", "10"),
 					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-3.item", ""),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.0.item", "item-1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.0.from", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.0.to", "1"),
 				),
 			},
+			{
+				Config:   testAccBucketsResourceMoveItemUpdateConfig(),
+				PlanOnly: true,
+			},
 			{
 				Config:      testAccBucketsResourceMoveItemUpdateNoMoveConfig(),
 				ExpectError: errorRe,
@@ -243,313 +269,1883 @@ func TestAccPersistentBucketsMoveItemResource(t *testing.T) {
 	})
 }
 
-func testAccBucketsResourceConfig() string {
-	return `
-resource "persistent_buckets" "test" {
-  bucket_capacity = 100
-  maximum_buckets = 1
-  items = {
-    item-1 = {
-		weight = 50
-		item   = "some string data here"
-	} 
-    item-2 = {
-		weight = 25
-		item   = null
-	} 
+func TestAccPersistentBucketsAffinityResource(t *testing.T) {
+	errorRe, err := regexp.Compile("unable to find bucket capacity")
+	if err != nil {
+		panic(err)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceAffinityConfig(30, false),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "3"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.big.weight", "50"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.replica-a.weight", "10"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "3"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.replica-b.weight", "10"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.sidecar-1.weight", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.sidecar-2.weight", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.%", "0"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceAffinityConfig(70, false),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "3"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.replica-b.weight", "10"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.sidecar-1.weight", "70"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.sidecar-2.weight", "30"),
+				),
+			},
+			{
+				Config:      testAccBucketsResourceAffinityConfig(70, true),
+				ExpectError: errorRe,
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsAffinityWeightsResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceAffinityWeightsConfig(10, 80, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "0"),
+				),
+			},
+			{
+				// The group fits with the new weights of all of its items
+				Config: testAccBucketsResourceAffinityWeightsConfig(30, 10, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-1.weight", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-2.weight", "10"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "0"),
+				),
+			},
+			{
+				// Placed items can't be kept apart from their affinity group either
+				Config:      testAccBucketsResourceAffinityWeightsConfig(30, 10, `anti_affinity_group = "spread"`),
+				ExpectError: regexp.MustCompile(`unable to place: item-1 and item-2`),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsShrinkResource(t *testing.T) {
+	errorRe, err := regexp.Compile("unable to find bucket capacity")
+	if err != nil {
+		panic(err)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceShrinkConfig(3, 60),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "3"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-1.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-2.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.item-3.weight", "60"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceShrinkConfig(2, 40),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "maximum_buckets", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-1.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-3.weight", "40"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-2.weight", "60"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceShrinkConfig(4, 40),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "maximum_buckets", "4"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "4"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.%", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.3.%", "0"),
+				),
+			},
+			{
+				Config:      testAccBucketsResourceShrinkConfig(1, 40),
+				ExpectError: errorRe,
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsCapacityResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceCapacityConfig(100),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_capacity", "100"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "3"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-1.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-2.weight", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-4.weight", "5"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-3.weight", "50"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceCapacityConfig(120),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_capacity", "120"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "3"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceCapacityConfig(80),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_capacity", "80"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-1.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-4.weight", "5"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-2.weight", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-3.weight", "50"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsRebalanceResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceRebalanceConfig(`item-2 = { weight = 40 }`, "null"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "rebalance_strategy", "pack"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-3.weight", "30"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceRebalanceConfig("", "null"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceRebalanceConfig("", `"1"`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "rebalance_trigger", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-1.weight", "50"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-3.weight", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsLazyResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceLazyConfig(`item-2 = { weight = 60 }`, false),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "maximum_buckets", "4"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "minimum_buckets", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-1.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-2.weight", "60"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceLazyConfig("", false),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "0"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceLazyConfig("", true),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "shrink_empty", "true"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "utilization.#", "1"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsMaxItemsResource(t *testing.T) {
+	errorRe, err := regexp.Compile("unable to find bucket capacity for: item-7 \\(weight 10, item limit of 2 reached\\)")
+	if err != nil {
+		panic(err)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceMaxItemsConfig(5),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "max_items_per_bucket", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.item-5.weight", "10"),
+				),
+			},
+			{
+				Config:      testAccBucketsResourceMaxItemsConfig(7),
+				ExpectError: errorRe,
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsDrainingResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceDrainingConfig("[]", false),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_status.0", "active"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceDrainingConfig("[0]", false),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_status.0", "draining"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_status.1", "active"),
+				),
+			},
+			{
+				// Only one item is moved per apply
+				Config: testAccBucketsResourceDrainingConfig("[0]", true),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-2.weight", "50"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.item-1.weight", "50"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_status.0", "draining"),
+				),
+				ExpectNonEmptyPlan: true,
+			},
+			{
+				Config: testAccBucketsResourceDrainingConfig("[0]", true),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.item-2.weight", "50"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_status.0", "drained"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_status.2", "active"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsPriorityResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourcePriorityConfig(""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.batch.weight", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.%", "0"),
+				),
+			},
+			{
+				// The batch item is evicted to make room for the critical item
+				Config: testAccBucketsResourcePriorityConfig(`
+    critical = {
+		weight   = 40
+		priority = 10
+	}`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.critical.weight", "40"),
+					resource.TestCheckNoResourceAttr("persistent_buckets.test", "buckets.1.batch.weight"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.batch.weight", "30"),
+					resource.TestCheckNoResourceAttr("persistent_buckets.test", "placements.batch"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsEvictionResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceEvictionConfig(""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-3", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "1"),
+				),
+			},
+			{
+				// item-2 is evicted by high first, and placed again once mid evicts item-1
+				Config: testAccBucketsResourceEvictionConfig(`
+    high = {
+		weight   = 60
+		priority = 10
+	}
+    mid = {
+		weight   = 35
+		priority = 5
+	}`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.high", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.mid", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.item-1.weight", "70"),
+				),
+			},
+			{
+				// A second plan with the same configuration doesn't move anything
+				Config: testAccBucketsResourceEvictionConfig(`
+    high = {
+		weight   = 60
+		priority = 10
+	}
+    mid = {
+		weight   = 35
+		priority = 5
+	}`),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsOverflowResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccBucketsResourceOverflowConfig("error", 2),
+				ExpectError: regexp.MustCompile("unable to find bucket capacity for: item-3"),
+			},
+			{
+				Config: testAccBucketsResourceOverflowConfig("report", 2),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.item-3.weight", "60"),
+				),
+			},
+			{
+				// Unplaced items are placed once there is room for them
+				Config: testAccBucketsResourceOverflowConfig("report", 3),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.2.item-3.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.%", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsOverflowRemovedResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceOverflowRemovedConfig(50, `item-2 = { weight = 50 }`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "0"),
+				),
+			},
+			{
+				// The room freed by the removed item is used before anything is reported
+				Config: testAccBucketsResourceOverflowRemovedConfig(70, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.%", "0"),
+				),
+			},
+			{
+				Config:   testAccBucketsResourceOverflowRemovedConfig(70, ""),
+				PlanOnly: true,
+			},
+			{
+				// Items that don't fit are reported with their new weight
+				Config: testAccBucketsResourceOverflowRemovedConfig(120, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckNoResourceAttr("persistent_buckets.test", "placements.item-1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "unplaced_items.item-1.weight", "120"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsOvercommitResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceOvercommitConfig(),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.%", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "utilization.0", "120"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "free_capacity.0", "-20"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "free_capacity.1", "40"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsPayloadsResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourcePayloadsConfig("sda"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.item-2.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_payloads.0.item-1.disks.0", "sda"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_payloads.1.item-2.tags.role", "db"),
+				),
+			},
+			{
+				// Changing a payload doesn't move the item
+				Config: testAccBucketsResourcePayloadsConfig("sdb"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.item-1.weight", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_payloads.0.item-1.disks.0", "sdb"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsOffsetsResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceOffsetsConfig("item-2", 30),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "offsets.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "offsets.item-2", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "offsets.item-3", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "fragmentation.0", "0"),
+				),
+			},
+			{
+				// The new item fills the hole left by the removed one
+				Config: testAccBucketsResourceOffsetsConfig("item-4", 20),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "offsets.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "offsets.item-4", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "offsets.item-3", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "fragmentation.0", "0.5"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsSlotsResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceSlotsConfig("lun-a"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.lun-a.slot", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.lun-b.slot", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.lun-c.slot", "0"),
+				),
+			},
+			{
+				// The freed slot is reused and the other items keep theirs
+				Config: testAccBucketsResourceSlotsConfig("lun-0"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.lun-0.slot", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.0.lun-b.slot", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.1.lun-c.slot", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsReservedResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceReservedConfig(20),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.db", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.web", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.cache", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "utilization.0", "50"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "reserved_capacity.0", "40"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "free_capacity.0", "10"),
+				),
+			},
+			{
+				// Growing within the reservation doesn't move anything
+				Config: testAccBucketsResourceReservedConfig(60),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.db", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.web", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.cache", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "utilization.0", "90"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "reserved_capacity.0", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "free_capacity.0", "10"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsReplicasResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceReplicasConfig(4, 90),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.cache", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.db#0", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.db#1", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.db#2", "3"),
+				),
+			},
+			{
+				// Only the replica in the removed bucket is placed again
+				Config: testAccBucketsResourceReplicasConfig(3, 60),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.cache", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.db#0", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.db#1", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.db#2", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.0.item", "db#2"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsLabelsResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceLabelsConfig(2),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.api", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.training", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.web", "1"),
+				),
+			},
+			{
+				// The item follows its required labels to another bucket
+				Config: testAccBucketsResourceLabelsConfig(0),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.api", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.training", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.web", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.0.item", "training"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsMoveCostResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceMoveCostConfig("minimize_weight", 20),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.grower", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.small-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.small-2", "0"),
+				),
+			},
+			{
+				// Moving the two small items is cheaper than moving the grown one
+				Config: testAccBucketsResourceMoveCostConfig("minimize_weight", 40),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.grower", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.small-1", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.small-2", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "2"),
+				),
+			},
+			{
+				// A single move, of the lighter of the items that free up enough room
+				Config: testAccBucketsResourceMoveCostConfig("minimize_count", 60),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.grower", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.big", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.0.item", "big"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsMoveCostRemovedResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceMoveCostRemovedConfig(50, `item-2 = { weight = 40 }`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "0"),
+				),
+			},
+			{
+				// The room freed by the removed item is used before looking for moves
+				Config: testAccBucketsResourceMoveCostRemovedConfig(70, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsExplainResource(t *testing.T) {
+	// Terraform wraps long diagnostics, so words may be separated by newlines
+	errorRe, err := regexp.Compile(`unable to find bucket capacity for: item-2[\s\S]*bucket\s+0:\s+capacity\s+\(60\s+of\s+80\s+used,\s+needs\s+35\)[\s\S]*raise\s+target_capacity\s+by\s+15`)
+	if err != nil {
+		panic(err)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccBucketsResourceExplainConfig(),
+				ExpectError: errorRe,
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsImportResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config:        testAccBucketsResourceImportConfig(),
+				ResourceName:  "persistent_buckets.test",
+				ImportState:   true,
+				ImportStateId: `[{"item-1": 50}, {"item-1": 30}]`,
+				ExpectError:   regexp.MustCompile("item item-1 is in both bucket 0 and bucket 1"),
+			},
+			{
+				Config:             testAccBucketsResourceImportConfig(),
+				ResourceName:       "persistent_buckets.test",
+				ImportState:        true,
+				ImportStateId:      `[{"item-1": 50, "item-2": 30}, {"item-3": 60}]`,
+				ImportStatePersist: true,
+			},
+			{
+				// The imported layout is kept, although item-3 would fit in the first bucket and
+				// the rebalance trigger is new to the state
+				Config: testAccBucketsResourceImportConfig(),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-3", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-4", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsRemapResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceRemapConfig(10, 4, "null"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-3", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-4", "3"),
+				),
+			},
+			{
+				// Doubling the capacity merges buckets 0+1 into 0 and 2+3 into 1
+				Config: testAccBucketsResourceRemapConfig(20, 2, `{ trigger = "double", merge = 2 }`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-3", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-4", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "3"),
+				),
+			},
+			{
+				// Halving it again splits them back
+				Config: testAccBucketsResourceRemapConfig(10, 4, `{ trigger = "halve", split = 2 }`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "4"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-3", "2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-4", "3"),
+				),
+			},
+			{
+				// The remap is only applied when the trigger changes
+				Config: testAccBucketsResourceRemapConfig(10, 4, `{ trigger = "halve", split = 2 }`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "0"),
+				),
+			},
+			{
+				Config:      testAccBucketsResourceRemapConfig(10, 4, `{ trigger = "both", merge = 2, split = 2 }`),
+				ExpectError: regexp.MustCompile("exactly one of mapping, merge and split must be set"),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsRemapRemovedResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceRemapRemovedConfig(`item-2 = { weight = 60 }`, "null"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "1"),
+				),
+			},
+			{
+				// Items removed in the same change are not remapped
+				Config: testAccBucketsResourceRemapRemovedConfig("", `{ trigger = "move", mapping = [0, 3] }`),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "buckets.#", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckNoResourceAttr("persistent_buckets.test", "placements.item-2"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "last_moves.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsDivisibleResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceDivisibleConfig(100, 100, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-1", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "placements.item-2", "1"),
+					resource.TestCheckNoResourceAttr("persistent_buckets.test", "placements.quota"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.quota", "40"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.1.quota", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.2.quota", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "utilization.2", "30"),
+				),
+			},
+			{
+				// Growth is added to the existing chunks
+				Config: testAccBucketsResourceDivisibleConfig(100, 120, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.quota", "40"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.1.quota", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.2.quota", "50"),
+				),
+			},
+			{
+				// Shrinking takes from the last chunks first
+				Config: testAccBucketsResourceDivisibleConfig(100, 45, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.quota", "35"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.1.quota", "10"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.2.%", "0"),
+				),
+			},
+			{
+				// Chunks are trimmed to a lowered capacity and the rest is placed again
+				Config: testAccBucketsResourceDivisibleConfig(80, 45, ""),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.quota", "20"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.1.quota", "10"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.2.quota", "15"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "free_capacity.0", "0"),
+				),
+			},
+			{
+				Config:      testAccBucketsResourceDivisibleConfig(80, 45, "replicas = 2"),
+				ExpectError: regexp.MustCompile("divisible items can't have affinity_group"),
+			},
+			{
+				Config:      strings.Replace(testAccBucketsResourceDivisibleConfig(80, 45, ""), "items = {", "payloads = { quota = { owner = \"team-a\" } }\n  items = {", 1),
+				ExpectError: regexp.MustCompile("payload for a divisible item: quota"),
+			},
+		},
+	})
+}
+
+func TestAccPersistentBucketsDivisibleDrainingResource(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:                 func() { testAccPreCheck(t) },
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccBucketsResourceDivisibleDrainingConfig("[]"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.quota-1", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.quota-2", "30"),
+				),
+			},
+			{
+				// Moving a chunk out of an evacuated bucket takes up the move budget
+				Config: testAccBucketsResourceDivisibleDrainingConfig("[0]"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.%", "1"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.quota-2", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.1.quota-1", "60"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_status.0", "draining"),
+				),
+			},
+			{
+				Config: testAccBucketsResourceDivisibleDrainingConfig("[0]"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.0.%", "0"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "chunks.1.quota-2", "30"),
+					resource.TestCheckResourceAttr("persistent_buckets.test", "bucket_status.0", "drained"),
+				),
+			},
+		},
+	})
+}
+
+func testAccBucketsResourceConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 1
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 25
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceAddConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 1
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 25
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+    item-4 = {
+		weight = 10
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceRemoveConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 1
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-3 = {
+		weight = 10
+	} 
+    item-4 = {
+		weight = 10
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceTooMuchConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 1
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-3 = {
+		weight = 10
+	} 
+    item-4 = {
+		weight = 10
+	} 
+    item-5 = {
+		weight = 50
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceGrowConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 25
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceGrowMoreConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 25
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+    item-4 = {
+		weight = 50
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceGrowEvenMoreConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 25
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+    item-4 = {
+		weight = 50
+	} 
+    item-5 = {
+		weight = 10
+	} 
+  }
+}
+`
+}
+func testAccBucketsResourceGrowTooMuchConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 25
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+    item-4 = {
+		weight = 50
+	} 
+    item-5 = {
+		weight = 125
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceTargetCapacityConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  target_capacity = 80 
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 50
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 30
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceTargetCapacityUpdateConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  target_capacity = 80 
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 70
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 30
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceMoveItemConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  target_capacity = 80 
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 40
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 30
+		item   = null
+	} 
+    item-3 = {
+		weight = 20
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceMoveItemUpdateConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  target_capacity = 80 
+  maximum_buckets = 2
+  move_items      = true
+  items = {
+    item-1 = {
+		weight = 80
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 30
+		item   = null
+	} 
+    item-3 = {
+		weight = 10
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceMoveItemUpdateNoMoveConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  target_capacity = 80 
+  maximum_buckets = 2
+  move_items      = false
+  items = {
+    item-1 = {
+		weight = 80
+		item   = "some string data here"
+	} 
+    item-2 = {
+		weight = 30
+		item   = null
+	} 
+    item-3 = {
+		weight = 30
+	} 
+  }
+}
+`
+}
+
+func testAccBucketsResourceAffinityConfig(sidecarWeight int, moreReplicas bool) string {
+	replicas := ""
+	if moreReplicas {
+		replicas = `
+    replica-c = {
+		weight              = 10
+		anti_affinity_group = "shard-1"
+	}
+    replica-d = {
+		weight              = 10
+		anti_affinity_group = "shard-1"
+	}`
+	}
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 3
+  items = {
+    big = {
+		weight = 50
+	}
+    replica-a = {
+		weight              = 10
+		anti_affinity_group = "shard-1"
+	}
+    replica-b = {
+		weight              = 10
+		anti_affinity_group = "shard-1"
+	}%s
+    sidecar-1 = {
+		weight         = %d
+		affinity_group = "service"
+	}
+    sidecar-2 = {
+		weight         = 30
+		affinity_group = "service"
+	}
+  }
+}
+`, replicas, sidecarWeight)
+}
+
+func testAccBucketsResourceAffinityWeightsConfig(firstWeight int, secondWeight int, antiAffinity string) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight         = %d
+		affinity_group = "group"
+		%s
+	}
+    item-2 = {
+		weight         = %d
+		affinity_group = "group"
+		%s
+	}
+  }
+}
+`, firstWeight, antiAffinity, secondWeight, antiAffinity)
+}
+
+func testAccBucketsResourceShrinkConfig(maximumBuckets int, lastWeight int) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = %d
+  items = {
+    item-1 = {
+		weight = 60
+	}
+    item-2 = {
+		weight = 60
+	}
+    item-3 = {
+		weight = %d
+	}
+  }
+}
+`, maximumBuckets, lastWeight)
+}
+
+func testAccBucketsResourceCapacityConfig(bucketCapacity int) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = %d
+  maximum_buckets = 3
+  items = {
+    item-1 = {
+		weight = 60
+	}
+    item-2 = {
+		weight = 30
+	}
+    item-3 = {
+		weight = 50
+	}
+    item-4 = {
+		weight = 5
+	}
+  }
+}
+`, bucketCapacity)
+}
+
+func testAccBucketsResourceRebalanceConfig(extraItem string, trigger string) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity     = 100
+  maximum_buckets     = 3
+  rebalance_trigger   = %s
+  max_moves_per_apply = 5
+  items = {
+    item-1 = {
+		weight = 50
+	}
+    %s
+    item-3 = {
+		weight = 30
+	}
+  }
+}
+`, trigger, extraItem)
+}
+
+func testAccBucketsResourceLazyConfig(extraItem string, shrinkEmpty bool) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 4
+  minimum_buckets = 1
+  shrink_empty    = %t
+  items = {
+    item-1 = {
+		weight = 60
+	}
+    %s
+  }
+}
+`, shrinkEmpty, extraItem)
+}
+
+func testAccBucketsResourceMaxItemsConfig(count int) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity      = 100
+  maximum_buckets      = 3
+  max_items_per_bucket = 2
+  items = { for i in range(1, %d) : "item-${i}" => { weight = 10 } }
+}
+`, count+1)
+}
+
+func testAccBucketsResourceDrainingConfig(drainingBuckets string, evacuate bool) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity     = 100
+  maximum_buckets     = 3
+  draining_buckets    = %s
+  evacuate            = %t
+  max_moves_per_apply = 1
+  items = {
+    item-1 = {
+		weight = 50
+	}
+    item-2 = {
+		weight = 50
+	}
+    item-3 = {
+		weight = 50
+	}
+    item-4 = {
+		weight = 10
+	}
+  }
+}
+`, drainingBuckets, evacuate)
+}
+
+func testAccBucketsResourcePriorityConfig(extraItem string) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 60
+	}
+    item-2 = {
+		weight = 30
+	}
+    item-3 = {
+		weight = 60
+	}
+    batch = {
+		weight   = 30
+		priority = -1
+	}%s
+  }
+}
+`, extraItem)
+}
+
+func testAccBucketsResourceEvictionConfig(extraItems string) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 70
+	}
+    item-2 = {
+		weight = 60
+	}
+    item-3 = {
+		weight   = 40
+		priority = 3
+	}%s
+  }
+}
+`, extraItems)
+}
+
+func testAccBucketsResourceOverflowConfig(onOverflow string, maximumBuckets int) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = %d
+  on_overflow     = %q
+  items = {
+    item-1 = {
+		weight = 60
+	}
+    item-2 = {
+		weight = 60
+	}
+    item-3 = {
+		weight = 60
+	}
+  }
+}
+`, maximumBuckets, onOverflow)
+}
+
+func testAccBucketsResourceOverflowRemovedConfig(weight int, extraItem string) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 1
+  on_overflow     = "report"
+  items = {
+    item-1 = {
+		weight = %d
+	}
+	%s
+  }
+}
+`, weight, extraItem)
+}
+
+func testAccBucketsResourceOvercommitConfig() string {
+	return `
+resource "persistent_buckets" "test" {
+  bucket_capacity          = 100
+  maximum_buckets          = 2
+  overcommit_ratio         = 1.5
+  bucket_overcommit_ratios = [null, 1]
+  items = {
+    item-1 = {
+		weight = 60
+	}
+    item-2 = {
+		weight = 60
+	}
+    item-3 = {
+		weight = 60
+	}
+  }
+}
+`
+}
+
+func testAccBucketsResourcePayloadsConfig(disk string) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity = 100
+  maximum_buckets = 2
+  items = {
+    item-1 = {
+		weight = 60
+	}
+    item-2 = {
+		weight = 60
+	}
+  }
+  payloads = {
+    item-1 = {
+		disks = [%q]
+	}
+    item-2 = {
+		tags = {
+			role = "db"
+		}
+	}
+  }
+}
+`, disk)
+}
+
+func testAccBucketsResourceOffsetsConfig(key string, weight int) string {
+	return fmt.Sprintf(`
+resource "persistent_buckets" "test" {
+  bucket_capacity  = 100
+  maximum_buckets  = 2
+  allocate_offsets = true
+  items = {
+    item-1 = {
+		weight = 30
+	}
+    %s = {
+		weight = %d
+	}
     item-3 = {
-		weight = 10
-	} 
+		weight = 30
+	}
   }
 }
-`
+`, key, weight)
 }
 
-func testAccBucketsResourceAddConfig() string {
-	return `
+func testAccBucketsResourceSlotsConfig(key string) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
-  bucket_capacity = 100
-  maximum_buckets = 1
+  bucket_capacity  = 100
+  maximum_buckets  = 2
+  slots_per_bucket = 2
   items = {
-    item-1 = {
-		weight = 50
-		item   = "some string data here"
-	} 
-    item-2 = {
-		weight = 25
-		item   = null
-	} 
-    item-3 = {
+    %s = {
 		weight = 10
-	} 
-    item-4 = {
+	}
+    lun-b = {
 		weight = 10
-	} 
+	}
+    lun-c = {
+		weight = 10
+	}
   }
 }
-`
+`, key)
 }
 
-func testAccBucketsResourceRemoveConfig() string {
-	return `
+func testAccBucketsResourceReservedConfig(dbWeight int) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
   bucket_capacity = 100
-  maximum_buckets = 1
+  maximum_buckets = 2
   items = {
-    item-1 = {
-		weight = 50
-		item   = "some string data here"
-	} 
-    item-3 = {
-		weight = 10
-	} 
-    item-4 = {
-		weight = 10
-	} 
+    db = {
+		weight          = %d
+		reserved_weight = 60
+	}
+    web = {
+		weight = 30
+	}
+    cache = {
+		weight = 30
+	}
   }
 }
-`
+`, dbWeight)
 }
 
-func testAccBucketsResourceTooMuchConfig() string {
-	return `
+func testAccBucketsResourceReplicasConfig(maximumBuckets int, cacheWeight int) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
   bucket_capacity = 100
-  maximum_buckets = 1
+  maximum_buckets = %d
   items = {
-    item-1 = {
-		weight = 50
-		item   = "some string data here"
-	} 
-    item-3 = {
-		weight = 10
-	} 
-    item-4 = {
-		weight = 10
-	} 
-    item-5 = {
-		weight = 50
-	} 
+    cache = {
+		weight = %d
+	}
+    db = {
+		weight   = 30
+		replicas = 3
+	}
   }
 }
-`
+`, maximumBuckets, cacheWeight)
 }
 
-func testAccBucketsResourceGrowConfig() string {
-	return `
+func testAccBucketsResourceLabelsConfig(gpuBucket int) string {
+	labels := []string{`{ region = "us" }`, `{ region = "eu" }`, `{ region = "eu" }`}
+	labels[gpuBucket] = strings.Replace(labels[gpuBucket], " }", `, gpu = "true" }`, 1)
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
   bucket_capacity = 100
-  maximum_buckets = 2
+  maximum_buckets = 3
+  bucket_labels   = [%s]
   items = {
-    item-1 = {
-		weight = 50
-		item   = "some string data here"
-	} 
-    item-2 = {
-		weight = 25
-		item   = null
-	} 
-    item-3 = {
-		weight = 10
-	} 
+    api = {
+		weight = 40
+	}
+    training = {
+		weight          = 50
+		required_labels = { gpu = "true" }
+	}
+    web = {
+		weight           = 40
+		preferred_labels = { region = "eu" }
+	}
   }
 }
-`
+`, strings.Join(labels, ", "))
 }
 
-func testAccBucketsResourceGrowMoreConfig() string {
-	return `
+func testAccBucketsResourceMoveCostConfig(moveCost string, growerWeight int) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
   bucket_capacity = 100
   maximum_buckets = 2
+  move_cost       = %q
   items = {
-    item-1 = {
+    big = {
 		weight = 50
-		item   = "some string data here"
-	} 
-    item-2 = {
-		weight = 25
-		item   = null
-	} 
-    item-3 = {
+	}
+    grower = {
+		weight = %d
+	}
+    small-1 = {
 		weight = 10
-	} 
-    item-4 = {
-		weight = 50
-	} 
+	}
+    small-2 = {
+		weight = 10
+	}
+    small-3 = {
+		weight = 10
+	}
   }
 }
-`
+`, moveCost, growerWeight)
 }
 
-func testAccBucketsResourceGrowEvenMoreConfig() string {
-	return `
+func testAccBucketsResourceMoveCostRemovedConfig(weight int, extraItem string) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
   bucket_capacity = 100
-  maximum_buckets = 2
+  maximum_buckets = 3
+  minimum_buckets = 1
+  shrink_empty    = true
+  move_cost       = "minimize_weight"
   items = {
     item-1 = {
-		weight = 50
-		item   = "some string data here"
-	} 
-    item-2 = {
-		weight = 25
-		item   = null
-	} 
-    item-3 = {
-		weight = 10
-	} 
-    item-4 = {
-		weight = 50
-	} 
-    item-5 = {
-		weight = 10
-	} 
+		weight = %d
+	}
+	%s
   }
 }
-`
+`, weight, extraItem)
 }
-func testAccBucketsResourceGrowTooMuchConfig() string {
+
+func testAccBucketsResourceExplainConfig() string {
 	return `
 resource "persistent_buckets" "test" {
   bucket_capacity = 100
-  maximum_buckets = 2
+  target_capacity = 80
+  maximum_buckets = 1
   items = {
     item-1 = {
-		weight = 50
-		item   = "some string data here"
-	} 
+		weight = 60
+	}
     item-2 = {
-		weight = 25
-		item   = null
-	} 
-    item-3 = {
-		weight = 10
-	} 
-    item-4 = {
-		weight = 50
-	} 
-    item-5 = {
-		weight = 125
-	} 
+		weight = 35
+	}
   }
 }
 `
 }
 
-func testAccBucketsResourceTargetCapacityConfig() string {
+func testAccBucketsResourceImportConfig() string {
 	return `
 resource "persistent_buckets" "test" {
-  bucket_capacity = 100
-  target_capacity = 80 
-  maximum_buckets = 2
+  bucket_capacity   = 100
+  maximum_buckets   = 2
+  rebalance_trigger = "1"
   items = {
     item-1 = {
 		weight = 50
-		item   = "some string data here"
-	} 
+	}
     item-2 = {
 		weight = 30
-		item   = null
-	} 
+	}
     item-3 = {
-		weight = 10
-	} 
+		weight = 15
+	}
+    item-4 = {
+		weight = 5
+	}
   }
 }
 `
 }
 
-func testAccBucketsResourceTargetCapacityUpdateConfig() string {
-	return `
+func testAccBucketsResourceRemapConfig(capacity int, buckets int, remap string) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
-  bucket_capacity = 100
-  target_capacity = 80 
-  maximum_buckets = 2
+  bucket_capacity = %d
+  maximum_buckets = %d
+  bucket_remap    = %s
   items = {
     item-1 = {
-		weight = 70
-		item   = "some string data here"
-	} 
+		weight = 10
+	}
     item-2 = {
-		weight = 30
-		item   = null
-	} 
+		weight = 10
+	}
     item-3 = {
 		weight = 10
-	} 
+	}
+    item-4 = {
+		weight = 10
+	}
   }
 }
-`
+`, capacity, buckets, remap)
 }
 
-func testAccBucketsResourceMoveItemConfig() string {
-	return `
+func testAccBucketsResourceRemapRemovedConfig(extraItem string, remap string) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
   bucket_capacity = 100
-  target_capacity = 80 
-  maximum_buckets = 2
+  maximum_buckets = 4
+  minimum_buckets = 1
+  shrink_empty    = true
+  bucket_remap    = %s
   items = {
     item-1 = {
-		weight = 40
-		item   = "some string data here"
-	} 
-    item-2 = {
-		weight = 30
-		item   = null
-	} 
-    item-3 = {
-		weight = 20
-	} 
+		weight = 60
+	}
+	%s
   }
 }
-`
+`, remap, extraItem)
 }
 
-func testAccBucketsResourceMoveItemUpdateConfig() string {
-	return `
+func testAccBucketsResourceDivisibleConfig(capacity int, quota int, extra string) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
-  bucket_capacity = 100
-  target_capacity = 80 
-  maximum_buckets = 2
-  move_items      = true
+  bucket_capacity = %d
+  maximum_buckets = 3
   items = {
     item-1 = {
-		weight = 80
-		item   = "some string data here"
-	} 
+		weight = 60
+	}
     item-2 = {
-		weight = 30
-		item   = null
-	} 
-    item-3 = {
-		weight = 10
-	} 
+		weight = 70
+	}
+    quota = {
+		weight    = %d
+		divisible = true
+		min_chunk = 10
+		%s
+	}
   }
 }
-`
+`, capacity, quota, extra)
 }
 
-func testAccBucketsResourceMoveItemUpdateNoMoveConfig() string {
-	return `
+func testAccBucketsResourceDivisibleDrainingConfig(drainingBuckets string) string {
+	return fmt.Sprintf(`
 resource "persistent_buckets" "test" {
-  bucket_capacity = 100
-  target_capacity = 80 
-  maximum_buckets = 2
-  move_items      = false
+  bucket_capacity     = 100
+  maximum_buckets     = 3
+  draining_buckets    = %s
+  evacuate            = true
+  max_moves_per_apply = 1
   items = {
-    item-1 = {
-		weight = 80
-		item   = "some string data here"
-	} 
-    item-2 = {
-		weight = 30
-		item   = null
-	} 
-    item-3 = {
-		weight = 30
-	} 
+    quota-1 = {
+		weight    = 60
+		divisible = true
+	}
+    quota-2 = {
+		weight    = 30
+		divisible = true
+	}
   }
 }
-`
+`, drainingBuckets)
+}
+
+func benchmarkModel(count int, bucketCapacity int64) *PersistentBucketsResourceModel {
+	objectType := map[string]attr.Type{
+		"weight":         types.Int64Type,
+		"item":           types.StringType,
+		"affinity_group": types.StringType,
+	}
+	items := make(map[string]attr.Value, count)
+	for i := 0; i < count; i++ {
+		group := types.StringNull()
+		if i%10 == 0 {
+			group = types.StringValue(fmt.Sprintf("group-%d", i%1000))
+		}
+		items[fmt.Sprintf("item-%05d", i)] = types.ObjectValueMust(objectType, map[string]attr.Value{
+			"weight":         types.Int64Value(int64(1 + (i*7919)%50)),
+			"item":           types.StringValue(fmt.Sprintf("data-%d", i)),
+			"affinity_group": group,
+		})
+	}
+	return &PersistentBucketsResourceModel{
+		Items:          types.MapValueMust(types.ObjectType{AttrTypes: objectType}, items),
+		MaximumBuckets: types.Int64Value(int64(count) * 30 / int64(bucketCapacity)),
+		BucketCapacity: types.Int64Value(bucketCapacity),
+		MoveItems:      types.BoolValue(true),
+	}
+}
+
+func BenchmarkWorkTheBucketsCreate(b *testing.B) {
+	for b.Loop() {
+		var diags diag.Diagnostics
+		workTheBuckets(benchmarkModel(20000, 1000), nil, &diags)
+		if diags.HasError() {
+			b.Fatalf("%v", diags)
+		}
+	}
+}
+
+func BenchmarkWorkTheBucketsUpdate(b *testing.B) {
+	var diags diag.Diagnostics
+	state := benchmarkModel(20000, 1000)
+	workTheBuckets(state, nil, &diags)
+	if diags.HasError() {
+		b.Fatalf("%v", diags)
+	}
+	for b.Loop() {
+		workTheBuckets(benchmarkModel(20000, 1000), state, &diags)
+		if diags.HasError() {
+			b.Fatalf("%v", diags)
+		}
+	}
 }
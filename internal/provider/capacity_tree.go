@@ -0,0 +1,72 @@
+package provider
+
+import (
+	"math"
+)
+
+// capacityTree is a segment tree over the free capacity of the buckets, used to find the first
+// bucket with enough room without scanning all the buckets before it
+type capacityTree struct {
+	size   int
+	used   []int64
+	limits []int64
+	free   []int64
+}
+
+// newCapacityTree builds the tree from the used capacities and limits of the buckets. The used
+// capacities are shared with the caller, so update has to be called whenever one changes.
+func newCapacityTree(used []int64, limits []int64) *capacityTree {
+	size := 1
+	for size < len(used) {
+		size *= 2
+	}
+	t := &capacityTree{size: size, used: used, limits: limits, free: make([]int64, 2*size)}
+	for idx := range t.free {
+		t.free[idx] = math.MinInt64
+	}
+	for idx := range used {
+		t.free[size+idx] = limits[idx] - used[idx]
+	}
+	for idx := size - 1; idx > 0; idx-- {
+		t.free[idx] = max(t.free[2*idx], t.free[2*idx+1])
+	}
+	return t
+}
+
+// update refreshes the free capacity of the bucket after its used capacity changed
+func (t *capacityTree) update(bucket int) {
+	idx := t.size + bucket
+	t.free[idx] = t.limits[bucket] - t.used[bucket]
+	for idx /= 2; idx > 0; idx /= 2 {
+		t.free[idx] = max(t.free[2*idx], t.free[2*idx+1])
+	}
+}
+
+// first returns the first bucket starting from the given one with room for the weight, or -1
+func (t *capacityTree) first(weight int64, from int) int {
+	return t.search(1, 0, t.size, weight, from)
+}
+
+func (t *capacityTree) search(node, lo, hi int, weight int64, from int) int {
+	if hi <= from || t.free[node] < weight {
+		return -1
+	}
+	if hi-lo == 1 {
+		return lo
+	}
+	mid := (lo + hi) / 2
+	if found := t.search(2*node, lo, mid, weight, from); found >= 0 {
+		return found
+	}
+	return t.search(2*node+1, mid, hi, weight, from)
+}
+
+// findCapacity returns the first eligible bucket with room for the weight, same as findCapacity
+func (t *capacityTree) findCapacity(weight int64, eligible func(int) bool) *int {
+	for idx := t.first(weight, 0); idx >= 0; idx = t.first(weight, idx+1) {
+		if eligible == nil || eligible(idx) {
+			return &idx
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package provider
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCapacityTree(t *testing.T) {
+	used := []int64{90, 50, 100, 20, 70}
+	limits := []int64{100, 100, 100, 100, 150}
+	tree := newCapacityTree(used, limits)
+	if idx := tree.first(30, 0); idx != 1 {
+		t.Errorf("Expected bucket 1, got %d", idx)
+	}
+	if idx := tree.first(30, 2); idx != 3 {
+		t.Errorf("Expected bucket 3, got %d", idx)
+	}
+	if idx := tree.first(80, 4); idx != 4 {
+		t.Errorf("Expected bucket 4, got %d", idx)
+	}
+	used[4] += 10
+	tree.update(4)
+	if idx := tree.first(80, 4); idx != -1 {
+		t.Errorf("Expected no bucket, got %d", idx)
+	}
+}
+
+func TestCapacityTreeFirstFit(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	used := make([]int64, 37)
+	limits := make([]int64, len(used))
+	for idx := range limits {
+		limits[idx] = 100 + int64(random.Intn(3))*25
+	}
+	tree := newCapacityTree(used, limits)
+	// Every third bucket is not eligible
+	eligible := func(idx int) bool {
+		return idx%3 != 2
+	}
+	for i := 0; i < 2000; i++ {
+		weight := int64(1 + random.Intn(60))
+		expected := findCapacity(&used, weight, limits, eligible)
+		found := tree.findCapacity(weight, eligible)
+		if (expected == nil) != (found == nil) || (expected != nil && *expected != *found) {
+			t.Fatalf("Weight %d: expected %v, got %v", weight, expected, found)
+		}
+		if found != nil {
+			used[*found] += weight
+			tree.update(*found)
+		}
+		// Free up some room now and then
+		if i%5 == 0 {
+			idx := random.Intn(len(used))
+			used[idx] = max(0, used[idx]-int64(random.Intn(80)))
+			tree.update(idx)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"slices"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+var chunksType = types.MapType{
+	ElemType: types.Int64Type,
+}
+
+// chunkMinimum returns the smallest chunk of an item, items lighter than min_chunk are placed as
+// a single chunk
+func chunkMinimum(weight, minChunk int64) int64 {
+	return max(min(minChunk, weight), 1)
+}
+
+// keepChunks returns the previous chunks of a divisible item that stay in place while the other
+// items are placed. Chunks in buckets that aren't kept are dropped and the others are trimmed to
+// the room left in their bucket, room being the largest chunk each bucket can hold. A smaller
+// weight is then taken from the last chunks first.
+func keepChunks(weight, minChunk int64, previous, room []int64, keep func(int) bool) []int64 {
+	minChunk = chunkMinimum(weight, minChunk)
+	chunks := slices.Clone(previous)
+	total := int64(0)
+	for idx, chunk := range chunks {
+		if chunk > 0 && keep(idx) {
+			chunks[idx] = min(chunk, room[idx])
+		} else {
+			chunks[idx] = 0
+		}
+		if chunks[idx] < minChunk {
+			// Too small to keep, the weight is placed again with the other chunks
+			chunks[idx] = 0
+		}
+		total += chunks[idx]
+	}
+	for idx := len(chunks) - 1; idx >= 0 && total > weight; idx-- {
+		cut := min(chunks[idx], total-weight)
+		if rest := chunks[idx] - cut; rest > 0 && rest < minChunk {
+			// Keep the smallest chunk, the rest is taken from the earlier chunks
+			cut = max(chunks[idx]-minChunk, 0)
+		}
+		chunks[idx] -= cut
+		total -= cut
+	}
+	// Chunks that can't be made smaller are dropped, and the other chunks grow back
+	for idx := len(chunks) - 1; idx >= 0 && total > weight; idx-- {
+		total -= chunks[idx]
+		chunks[idx] = 0
+	}
+	return chunks
+}
+
+// growChunks places the weight of a divisible item that isn't in its chunks yet. It is added to
+// the existing chunks first, before new chunks of at least minChunk fill the room left in the
+// buckets in order. Only eligible buckets take more weight. Returns the chunk in each bucket and
+// the weight that didn't fit.
+func growChunks(weight, minChunk int64, chunks, room []int64, eligible func(int) bool) ([]int64, int64) {
+	minChunk = chunkMinimum(weight, minChunk)
+	chunks = slices.Clone(chunks)
+	total := int64(0)
+	for _, chunk := range chunks {
+		total += chunk
+	}
+	for idx := 0; idx < len(chunks) && total < weight; idx++ {
+		if chunks[idx] > 0 && room[idx] > chunks[idx] && eligible(idx) {
+			grow := min(weight-total, room[idx]-chunks[idx])
+			chunks[idx] += grow
+			total += grow
+		}
+	}
+	for idx := 0; idx < len(chunks) && total < weight; idx++ {
+		if chunks[idx] > 0 || !eligible(idx) {
+			continue
+		}
+		needed := weight - total
+		size := min(needed, room[idx])
+		if rest := needed - size; rest > 0 && rest < minChunk {
+			// Leave enough for the next chunk
+			size = needed - minChunk
+		}
+		if size < minChunk {
+			continue
+		}
+		chunks[idx] = size
+		total += size
+	}
+	return chunks, weight - total
+}
+
+// stateChunks returns the chunks of each item in the state by bucket
+func stateChunks(chunks types.List) map[string]map[int]int64 {
+	items := make(map[string]map[int]int64, 0)
+	if chunks.IsNull() || chunks.IsUnknown() {
+		return items
+	}
+	for bidx, bucket := range chunks.Elements() {
+		m, ok := bucket.(basetypes.MapValue)
+		if !ok {
+			continue
+		}
+		for k, v := range m.Elements() {
+			if size, ok := v.(basetypes.Int64Value); ok && size.ValueInt64() > 0 {
+				if _, ok := items[k]; !ok {
+					items[k] = make(map[int]int64, 0)
+				}
+				items[k][bidx] = size.ValueInt64()
+			}
+		}
+	}
+	return items
+}
+
+// createChunks creates the chunks output with the chunk of each item in each bucket
+func createChunks(bucketCount int, chunks map[string][]int64, diagnostics *diag.Diagnostics) types.List {
+	tfChunks := make([]attr.Value, 0, bucketCount)
+	for bidx := 0; bidx < bucketCount; bidx++ {
+		bucketChunks := make(map[string]attr.Value, 0)
+		for k, itemChunks := range chunks {
+			if bidx < len(itemChunks) && itemChunks[bidx] > 0 {
+				bucketChunks[k] = types.Int64Value(itemChunks[bidx])
+			}
+		}
+		tfBucket, diags := types.MapValue(types.Int64Type, bucketChunks)
+		diagnostics.Append(diags...)
+		tfChunks = append(tfChunks, tfBucket)
+	}
+	chunksValue, diags := types.ListValue(chunksType, tfChunks)
+	diagnostics.Append(diags...)
+	return chunksValue
+}
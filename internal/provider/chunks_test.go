@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestKeepChunks(t *testing.T) {
+	all := func(int) bool { return true }
+	// Shrinking takes from the last chunks and keeps the smallest chunk
+	if chunks := keepChunks(45, 10, []int64{40, 30, 50}, []int64{100, 100, 100}, all); !slices.Equal(chunks, []int64{35, 10, 0}) {
+		t.Errorf("Expected the last chunks to shrink, got %v", chunks)
+	}
+	// Chunks are trimmed to the room left in their bucket
+	if chunks := keepChunks(90, 10, []int64{90}, []int64{50}, all); !slices.Equal(chunks, []int64{50}) {
+		t.Errorf("Expected the chunk to be trimmed, got %v", chunks)
+	}
+	if chunks := keepChunks(90, 10, []int64{45, 45}, []int64{100, 5}, all); !slices.Equal(chunks, []int64{45, 0}) {
+		t.Errorf("Expected the chunk below the minimum to be dropped, got %v", chunks)
+	}
+	// Chunks in buckets that aren't kept are dropped
+	if chunks := keepChunks(90, 10, []int64{45, 45}, []int64{100, 100}, func(idx int) bool { return idx != 0 }); !slices.Equal(chunks, []int64{0, 45}) {
+		t.Errorf("Expected the chunk in the first bucket to be dropped, got %v", chunks)
+	}
+	// Chunks that can't be made smaller are dropped
+	if chunks := keepChunks(15, 10, []int64{10, 10, 10}, []int64{100, 100, 100}, all); !slices.Equal(chunks, []int64{10, 0, 0}) {
+		t.Errorf("Expected the last chunks to be dropped, got %v", chunks)
+	}
+}
+
+func TestGrowChunks(t *testing.T) {
+	all := func(int) bool { return true }
+	chunks, left := growChunks(100, 10, []int64{0, 0, 0}, []int64{40, 30, 70}, all)
+	if !slices.Equal(chunks, []int64{40, 30, 30}) || left != 0 {
+		t.Errorf("Expected the chunks to fill the buckets in order, got %v (%d left)", chunks, left)
+	}
+
+	// Growth is added to the existing chunks first
+	chunks, _ = growChunks(120, 10, []int64{0, 30, 0}, []int64{40, 60, 70}, all)
+	if !slices.Equal(chunks, []int64{40, 60, 20}) {
+		t.Errorf("Expected the existing chunk to grow first, got %v", chunks)
+	}
+
+	// Chunks smaller than the minimum are not created
+	chunks, left = growChunks(30, 10, []int64{0, 0, 0}, []int64{25, 5, 20}, all)
+	if !slices.Equal(chunks, []int64{20, 0, 10}) || left != 0 {
+		t.Errorf("Expected room to be left for the last chunk, got %v (%d left)", chunks, left)
+	}
+	chunks, left = growChunks(30, 10, []int64{0, 0}, []int64{25, 5}, all)
+	if left != 10 {
+		t.Errorf("Expected 10 to be left over, got %v (%d left)", chunks, left)
+	}
+
+	// Items lighter than the minimum are a single chunk
+	chunks, _ = growChunks(5, 10, []int64{0, 0}, []int64{0, 20}, all)
+	if !slices.Equal(chunks, []int64{0, 5}) {
+		t.Errorf("Expected a single chunk, got %v", chunks)
+	}
+
+	// Only eligible buckets take more weight, existing chunks included
+	chunks, _ = growChunks(80, 1, []int64{50, 0}, []int64{100, 100}, func(idx int) bool { return idx != 0 })
+	if !slices.Equal(chunks, []int64{50, 30}) {
+		t.Errorf("Expected the growth in the eligible bucket, got %v", chunks)
+	}
+}
@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+)
+
+// weightPath returns the path of the weight of an item in the configuration, replicas point to
+// the item they were created from
+func weightPath(key string, item BucketItem) path.Path {
+	if item.ReplicaOf != "" {
+		key = item.ReplicaOf
+	}
+	return path.Root("items").AtMapKey(key).AtName("weight")
+}
+
+// antiAffinityConflicts returns the sorted keys of the items in the bucket that can't share the
+// bucket with the unit
+func antiAffinityConflicts(bucket map[string]BucketItem, unit map[string]BucketItem) []string {
+	conflicts := make([]string, 0)
+	for k, bv := range bucket {
+		if _, ok := unit[k]; ok {
+			continue
+		}
+		if antiAffinityConflict(map[string]BucketItem{k: bv}, unit) {
+			conflicts = append(conflicts, k)
+		}
+	}
+	slices.Sort(conflicts)
+	return conflicts
+}
+
+// capacityIncrease returns how much the capacity has to be raised so that the limit of a bucket
+// with the overcommit ratio reaches the needed weight
+func capacityIncrease(capacity int64, ratio float64, needed int64) int64 {
+	increase := max(int64(math.Ceil(float64(needed)/ratio))-capacity, 0)
+	for int64(math.Floor(float64(capacity+increase)*ratio)) < needed {
+		increase++
+	}
+	return increase
+}
+
+// placementView is the state of the buckets that placement failures are explained with. The
+// buckets and capacities are shared with the placement, so the view is always current.
+type placementView struct {
+	buckets        []map[string]BucketItem
+	capacities     []int64
+	labels         []map[string]string
+	draining       map[int]bool
+	ratios         []float64
+	maxItems       int
+	allocate       bool
+	bucketCapacity int64
+	// newRatio is the overcommit ratio of a bucket that is added
+	newRatio float64
+}
+
+// limitReached names the limit that prevented placing the unit in any bucket
+func (v placementView) limitReached(unit map[string]BucketItem, unitWeight int64, limits []int64) string {
+	// Explain the labels when no bucket has the required ones
+	matching := false
+	var missing []string
+	for idx := range v.capacities {
+		bucketMissing := missingLabels(v.labels[idx], unit, false)
+		if len(bucketMissing) == 0 {
+			matching = true
+			break
+		}
+		if missing == nil || len(bucketMissing) < len(missing) {
+			missing = bucketMissing
+		}
+	}
+	if !matching {
+		return fmt.Sprintf("no bucket has the required labels, closest bucket lacks %s", strings.Join(missing, ", "))
+	}
+	if v.maxItems > 0 {
+		for idx, used := range v.capacities {
+			if used+unitWeight <= limits[idx] && len(v.buckets[idx])+len(unit) > v.maxItems {
+				return fmt.Sprintf("item limit of %d reached", v.maxItems)
+			}
+		}
+	}
+	// Replicas and anti-affinity groups need as many buckets as items
+	for idx, used := range v.capacities {
+		if used+unitWeight <= limits[idx] && !v.draining[idx] && antiAffinityConflict(v.buckets[idx], unit) {
+			return fmt.Sprintf("anti-affinity limit of %d buckets reached", len(v.capacities))
+		}
+	}
+	if limits := limits[:len(v.capacities)]; slices.Min(limits) != slices.Max(limits) {
+		return fmt.Sprintf("capacities of %v reached", limits)
+	}
+	return fmt.Sprintf("capacity of %d reached", limits[0])
+}
+
+// placementDetails explains why each bucket rejects the unit and suggests a fix. The unit is
+// being moved out of the from bucket, if any.
+func (v placementView) placementDetails(unit map[string]BucketItem, unitWeight int64, limits []int64, capacityAttr string, capacity int64, from int) string {
+	lines := make([]string, 0, len(v.capacities)+1)
+	// Smallest capacity increase that makes room for the unit in a bucket otherwise accepting it
+	increase := int64(-1)
+	for idx, used := range v.capacities {
+		count := len(v.buckets[idx])
+		for k, uv := range unit {
+			if _, ok := v.buckets[idx][k]; ok {
+				used -= uv.footprint()
+				count--
+			}
+		}
+		reasons := make([]string, 0)
+		if idx == from {
+			reasons = append(reasons, "the items are moved out of it")
+		}
+		if v.draining[idx] {
+			reasons = append(reasons, "draining")
+		}
+		if missing := missingLabels(v.labels[idx], unit, false); len(missing) > 0 {
+			reasons = append(reasons, fmt.Sprintf("missing labels %s", strings.Join(missing, ", ")))
+		}
+		if conflicts := antiAffinityConflicts(v.buckets[idx], unit); len(conflicts) > 0 {
+			reasons = append(reasons, fmt.Sprintf("anti-affinity with %s", strings.Join(conflicts, ", ")))
+		}
+		if v.maxItems > 0 && count+len(unit) > v.maxItems {
+			reasons = append(reasons, fmt.Sprintf("count limit of %d items reached", v.maxItems))
+		}
+		if used+unitWeight > limits[idx] {
+			if len(reasons) == 0 {
+				needed := capacityIncrease(capacity, v.ratios[idx], used+unitWeight)
+				if increase < 0 || needed < increase {
+					increase = needed
+				}
+			}
+			reasons = append(reasons, fmt.Sprintf("capacity (%d of %d used, needs %d)", used, limits[idx], unitWeight))
+		}
+		if len(reasons) == 0 && v.allocate {
+			reasons = append(reasons, "no contiguous range of offsets")
+		}
+		lines = append(lines, fmt.Sprintf("bucket %d: %s", idx, strings.Join(reasons, ", ")))
+	}
+
+	fixes := make([]string, 0)
+	// A new bucket has no labels and the default overcommit ratio
+	requiresLabels := false
+	for _, uv := range unit {
+		requiresLabels = requiresLabels || len(uv.RequiredLabels) > 0
+	}
+	bucketCount := len(v.capacities)
+	if unitWeight <= overcommitLimits(capacity, []float64{v.newRatio})[0] && (v.maxItems == 0 || len(unit) <= v.maxItems) {
+		if requiresLabels {
+			fixes = append(fixes, fmt.Sprintf("needs 1 more bucket with labels %s (maximum_buckets = %d)", strings.Join(missingLabels(nil, unit, false), ", "), bucketCount+1))
+		} else {
+			fixes = append(fixes, fmt.Sprintf("needs 1 more bucket (maximum_buckets = %d)", bucketCount+1))
+		}
+	}
+	if increase > 0 {
+		fix := fmt.Sprintf("raise %s by %d", capacityAttr, increase)
+		if capacityAttr != "bucket_capacity" && capacity+increase > v.bucketCapacity {
+			fix += fmt.Sprintf(" (and bucket_capacity to at least %d)", capacity+increase)
+		}
+		fixes = append(fixes, fix)
+	}
+	if len(fixes) > 0 {
+		lines = append(lines, fmt.Sprintf("suggested fix: %s", strings.Join(fixes, " or ")))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCapacityIncrease(t *testing.T) {
+	if increase := capacityIncrease(90, 1, 105); increase != 15 {
+		t.Errorf("Expected an increase of 15, got %d", increase)
+	}
+	// The overcommit ratio applies to the raised capacity as well
+	if increase := capacityIncrease(100, 1.5, 165); increase != 10 {
+		t.Errorf("Expected an increase of 10, got %d", increase)
+	}
+	if increase := capacityIncrease(100, 1, 80); increase != 0 {
+		t.Errorf("Expected no increase, got %d", increase)
+	}
+}
+
+func TestAntiAffinityConflicts(t *testing.T) {
+	bucket := map[string]BucketItem{
+		"a":    {Weight: 10, AntiAffinityGroup: "shard"},
+		"b":    {Weight: 10},
+		"db#0": {Weight: 10, ReplicaOf: "db"},
+	}
+	unit := map[string]BucketItem{"db#1": {Weight: 10, AntiAffinityGroup: "shard", ReplicaOf: "db"}}
+	if conflicts := antiAffinityConflicts(bucket, unit); !slices.Equal(conflicts, []string{"a", "db#0"}) {
+		t.Errorf("Expected a and db#0 to conflict, got %v", conflicts)
+	}
+}
+
+func TestWeightPath(t *testing.T) {
+	if p := weightPath("db#1", BucketItem{ReplicaOf: "db"}).String(); p != `items["db"].weight` {
+		t.Errorf("Expected the path of the replicated item, got %s", p)
+	}
+}
+
+func TestPlacementView(t *testing.T) {
+	view := placementView{
+		buckets:        []map[string]BucketItem{{"a": {Weight: 80}}, {"b": {Weight: 50}}},
+		capacities:     []int64{80, 50},
+		labels:         []map[string]string{nil, nil},
+		draining:       map[int]bool{1: true},
+		ratios:         []float64{1, 1},
+		bucketCapacity: 100,
+		newRatio:       1,
+	}
+	unit := map[string]BucketItem{"c": {Weight: 40}}
+	if reason := view.limitReached(unit, 40, []int64{100, 100}); reason != "capacity of 100 reached" {
+		t.Errorf("Expected the capacity to be reached, got %s", reason)
+	}
+	expected := "bucket 0: capacity (80 of 100 used, needs 40)\nbucket 1: draining\nsuggested fix: needs 1 more bucket (maximum_buckets = 3) or raise bucket_capacity by 20"
+	if details := view.placementDetails(unit, 40, []int64{100, 100}, "bucket_capacity", 100, -1); details != expected {
+		t.Errorf("Expected the buckets to be explained, got %s", details)
+	}
+
+	unit = map[string]BucketItem{"c": {Weight: 10, RequiredLabels: map[string]string{"zone": "a"}}}
+	if reason := view.limitReached(unit, 10, []int64{100, 100}); reason != "no bucket has the required labels, closest bucket lacks zone=a" {
+		t.Errorf("Expected the labels to be explained, got %s", reason)
+	}
+}
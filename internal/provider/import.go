@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// parseLayout reads a bucket layout from the import ID, either as JSON or from a file containing
+// the JSON. The layout is a list of buckets, each a map of item keys to weights.
+func parseLayout(id string) ([]map[string]int64, error) {
+	data := []byte(strings.TrimSpace(id))
+	if !strings.HasPrefix(string(data), "[") {
+		contents, err := os.ReadFile(id)
+		if err != nil {
+			return nil, fmt.Errorf("import ID is neither a JSON layout nor a readable file: %w", err)
+		}
+		data = contents
+	}
+	var layout []map[string]int64
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return nil, fmt.Errorf("layout must be a list of buckets of item keys to weights: %w", err)
+	}
+	seen := make(map[string]int, 0)
+	for bidx, bucket := range layout {
+		for k, weight := range bucket {
+			if weight < 1 {
+				return nil, fmt.Errorf("item %s in bucket %d has weight %d, weights must be at least 1", k, bidx, weight)
+			}
+			if other, ok := seen[k]; ok {
+				return nil, fmt.Errorf("item %s is in both bucket %d and bucket %d", k, other, bidx)
+			}
+			seen[k] = bidx
+		}
+	}
+	return layout, nil
+}
+
+// layoutBuckets converts a bucket layout into the value of the buckets attribute
+func layoutBuckets(layout []map[string]int64, diagnostics *diag.Diagnostics) types.List {
+	tfBuckets := make([]attr.Value, 0, len(layout))
+	for _, bucket := range layout {
+		items := make(map[string]attr.Value, len(bucket))
+		for k, weight := range bucket {
+			obj, diags := types.ObjectValue(itemObjectType.AttrTypes, map[string]attr.Value{
+				"weight": types.Int64Value(weight),
+				"item":   types.StringNull(),
+				"slot":   types.Int64Null(),
+			})
+			diagnostics.Append(diags...)
+			items[k] = obj
+		}
+		tfBucket, diags := types.MapValue(itemObjectType, items)
+		diagnostics.Append(diags...)
+		tfBuckets = append(tfBuckets, tfBucket)
+	}
+	buckets, diags := types.ListValue(bucketsType, tfBuckets)
+	diagnostics.Append(diags...)
+	return buckets
+}
@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLayout(t *testing.T) {
+	layout, err := parseLayout(`[{"a": 50, "b": 30}, {}, {"c": 60}]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []map[string]int64{{"a": 50, "b": 30}, {}, {"c": 60}}
+	if !reflect.DeepEqual(layout, expected) {
+		t.Errorf("Expected %v got %v", expected, layout)
+	}
+
+	file := filepath.Join(t.TempDir(), "layout.json")
+	if err := os.WriteFile(file, []byte(`[{"a": 50}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	layout, err = parseLayout(file)
+	if err != nil || !reflect.DeepEqual(layout, []map[string]int64{{"a": 50}}) {
+		t.Errorf("Expected the layout from the file, got %v (%v)", layout, err)
+	}
+}
+
+func TestParseLayoutInvalid(t *testing.T) {
+	for _, id := range []string{
+		`[{"a": 50}, {"a": 10}]`,
+		`[{"a": 0}]`,
+		`[{"a": "heavy"}]`,
+		`{"a": 50}`,
+		filepath.Join(t.TempDir(), "missing.json"),
+	} {
+		if _, err := parseLayout(id); err == nil {
+			t.Errorf("Expected an error for %s", id)
+		}
+	}
+}
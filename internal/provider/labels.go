@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+// stringMap converts a map of strings from the configuration, null and unknown values are skipped
+func stringMap(v attr.Value) map[string]string {
+	m, ok := v.(basetypes.MapValue)
+	if !ok || m.IsNull() || m.IsUnknown() {
+		return nil
+	}
+	values := make(map[string]string, len(m.Elements()))
+	for k, e := range m.Elements() {
+		if s, ok := e.(basetypes.StringValue); ok && !s.IsNull() && !s.IsUnknown() {
+			values[k] = s.ValueString()
+		}
+	}
+	return values
+}
+
+// missingLabels returns the labels required (or preferred) by the items of the unit that the
+// bucket doesn't have, as sorted key=value pairs
+func missingLabels(labels map[string]string, unit map[string]BucketItem, preferred bool) []string {
+	missing := make([]string, 0)
+	for _, v := range unit {
+		selector := v.RequiredLabels
+		if preferred {
+			selector = v.PreferredLabels
+		}
+		for lk, lv := range selector {
+			if value, ok := labels[lk]; ok && value == lv {
+				continue
+			}
+			if label := fmt.Sprintf("%s=%s", lk, lv); !slices.Contains(missing, label) {
+				missing = append(missing, label)
+			}
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+// hasPreferences checks if any of the items of the unit prefers labels
+func hasPreferences(unit map[string]BucketItem) bool {
+	for _, v := range unit {
+		if len(v.PreferredLabels) > 0 {
+			return true
+		}
+	}
+	return false
+}
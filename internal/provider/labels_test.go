@@ -0,0 +1,27 @@
+package provider
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMissingLabels(t *testing.T) {
+	labels := map[string]string{"gpu": "true", "region": "eu"}
+	unit := map[string]BucketItem{
+		"a": {Weight: 10, RequiredLabels: map[string]string{"gpu": "true"}},
+		"b": {Weight: 10, RequiredLabels: map[string]string{"region": "us"}, PreferredLabels: map[string]string{"disk": "ssd"}},
+	}
+	if missing := missingLabels(labels, unit, false); !slices.Equal(missing, []string{"region=us"}) {
+		t.Errorf("Expected region=us to be missing, got %v", missing)
+	}
+	if missing := missingLabels(labels, unit, true); !slices.Equal(missing, []string{"disk=ssd"}) {
+		t.Errorf("Expected disk=ssd to be missing, got %v", missing)
+	}
+	// Buckets without labels only fit items without required labels
+	if missing := missingLabels(nil, map[string]BucketItem{"c": {Weight: 10}}, false); len(missing) > 0 {
+		t.Errorf("Expected no missing labels, got %v", missing)
+	}
+	if !hasPreferences(unit) || hasPreferences(map[string]BucketItem{"c": {Weight: 10}}) {
+		t.Errorf("Expected only the unit with preferred labels to have preferences")
+	}
+}
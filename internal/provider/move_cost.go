@@ -0,0 +1,65 @@
+package provider
+
+const (
+	moveCostWeight = "minimize_weight"
+	moveCostCount  = "minimize_count"
+)
+
+// moveSearchLimit bounds the number of sets of moves that are considered
+const moveSearchLimit = 10000
+
+// movesCost is the cost of moving a set of items out of a bucket
+type movesCost struct {
+	count  int
+	weight int64
+}
+
+// less compares the costs by weight or by count first, depending on the strategy
+func (c movesCost) less(other movesCost, strategy string) bool {
+	if strategy == moveCostCount {
+		if c.count != other.count {
+			return c.count < other.count
+		}
+		return c.weight < other.weight
+	}
+	if c.weight != other.weight {
+		return c.weight < other.weight
+	}
+	return c.count < other.count
+}
+
+// cheapestMoves searches for the cheapest set of units to move out of a bucket to free up the
+// overflowing weight. Sets are only accepted if feasible says the units can be moved to other
+// buckets. Returns the indexes of the units to move, or nil if there is no such set, and whether
+// the search was cut short by moveSearchLimit.
+func cheapestMoves(units []map[string]BucketItem, weights []int64, overflow int64, strategy string, feasible func([]int) bool) ([]int, bool) {
+	var best []int
+	var bestCost movesCost
+	candidates := 0
+	current := make([]int, 0, len(units))
+	var search func(next int, cost movesCost, freed int64)
+	search = func(next int, cost movesCost, freed int64) {
+		if candidates >= moveSearchLimit {
+			return
+		}
+		candidates++
+		if best != nil && !cost.less(bestCost, strategy) {
+			return
+		}
+		if freed >= overflow {
+			// Adding more units only makes the set more expensive
+			if feasible(current) {
+				best = append([]int{}, current...)
+				bestCost = cost
+			}
+			return
+		}
+		for idx := next; idx < len(units); idx++ {
+			current = append(current, idx)
+			search(idx+1, movesCost{count: cost.count + len(units[idx]), weight: cost.weight + weights[idx]}, freed+weights[idx])
+			current = current[:len(current)-1]
+		}
+	}
+	search(0, movesCost{}, 0)
+	return best, candidates >= moveSearchLimit
+}
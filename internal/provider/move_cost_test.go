@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheapestMoves(t *testing.T) {
+	units := []map[string]BucketItem{
+		{"big": {Weight: 50}},
+		{"g": {Weight: 40}},
+		{"s1": {Weight: 10}},
+		{"s2": {Weight: 10}},
+	}
+	weights := []int64{50, 40, 10, 10}
+	feasible := func([]int) bool { return true }
+	if picked, _ := cheapestMoves(units, weights, 20, moveCostWeight, feasible); !slices.Equal(picked, []int{2, 3}) {
+		t.Errorf("Expected the two small units to be moved, got %v", picked)
+	}
+	if picked, _ := cheapestMoves(units, weights, 20, moveCostCount, feasible); !slices.Equal(picked, []int{1}) {
+		t.Errorf("Expected the lighter single unit to be moved, got %v", picked)
+	}
+
+	// Sets that can't be moved elsewhere are skipped
+	noSmall := func(picked []int) bool { return !slices.Contains(picked, 2) }
+	if picked, _ := cheapestMoves(units, weights, 20, moveCostWeight, noSmall); !slices.Equal(picked, []int{1}) {
+		t.Errorf("Expected g to be moved, got %v", picked)
+	}
+	if picked, _ := cheapestMoves(units, weights, 200, moveCostWeight, feasible); picked != nil {
+		t.Errorf("Expected no set of moves, got %v", picked)
+	}
+}
+
+func TestCheapestMovesLimit(t *testing.T) {
+	units := make([]map[string]BucketItem, 0)
+	weights := make([]int64, 0)
+	for i := 0; i < 40; i++ {
+		units = append(units, map[string]BucketItem{})
+		weights = append(weights, 1)
+	}
+	// Nothing is feasible, so the search runs into the limit
+	picked, truncated := cheapestMoves(units, weights, 20, moveCostWeight, func([]int) bool { return false })
+	if picked != nil || !truncated {
+		t.Errorf("Expected the search to be cut short, got %v %v", picked, truncated)
+	}
+}
@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+// offsetRange is a [Start, End) range inside a bucket
+type offsetRange struct {
+	Start int64
+	End   int64
+}
+
+// freeRanges returns the holes between the sorted used ranges up to the limit
+func freeRanges(used []offsetRange, limit int64) []offsetRange {
+	holes := make([]offsetRange, 0)
+	start := int64(0)
+	for _, r := range used {
+		if r.Start > start {
+			holes = append(holes, offsetRange{Start: start, End: r.Start})
+		}
+		start = max(start, r.End)
+	}
+	if start < limit {
+		holes = append(holes, offsetRange{Start: start, End: limit})
+	}
+	return holes
+}
+
+// allocateOffsets assigns each item in the bucket a range of its weight below the limit. Items keep
+// their previous offset if it's still free, items that grew are only considered after the others.
+// The remaining items are placed in the first hole that fits them, ordered by key. Returns the
+// offsets and the keys of the items that didn't fit in any hole.
+func allocateOffsets(bucket map[string]BucketItem, fixed map[string]int64, grown map[string]bool, limit int64) (map[string]int64, []string) {
+	offsets := make(map[string]int64, len(bucket))
+	used := make([]offsetRange, 0, len(bucket))
+	insert := func(k string, r offsetRange) {
+		offsets[k] = r.Start
+		pos, _ := slices.BinarySearchFunc(used, r, func(a, b offsetRange) int {
+			return cmp.Compare(a.Start, b.Start)
+		})
+		used = slices.Insert(used, pos, r)
+	}
+
+	keys := slices.Sorted(maps.Keys(bucket))
+	fixedKeys := make([]string, 0, len(fixed))
+	for _, k := range keys {
+		if _, ok := fixed[k]; ok {
+			fixedKeys = append(fixedKeys, k)
+		}
+	}
+	slices.SortStableFunc(fixedKeys, func(a, b string) int {
+		if grown[a] != grown[b] {
+			if grown[a] {
+				return 1
+			}
+			return -1
+		}
+		return cmp.Compare(fixed[a], fixed[b])
+	})
+	for _, k := range fixedKeys {
+		r := offsetRange{Start: fixed[k], End: fixed[k] + bucket[k].footprint()}
+		if r.Start < 0 || r.End > limit {
+			continue
+		}
+		overlaps := false
+		for _, u := range used {
+			if r.Start < u.End && u.Start < r.End {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			insert(k, r)
+		}
+	}
+
+	unallocated := make([]string, 0)
+	for _, k := range keys {
+		if _, ok := offsets[k]; ok {
+			continue
+		}
+		found := false
+		for _, hole := range freeRanges(used, limit) {
+			if hole.End-hole.Start >= bucket[k].footprint() {
+				insert(k, offsetRange{Start: hole.Start, End: hole.Start + bucket[k].footprint()})
+				found = true
+				break
+			}
+		}
+		if !found {
+			unallocated = append(unallocated, k)
+		}
+	}
+	return offsets, unallocated
+}
+
+// fragmentation returns the share of the free space in a bucket that is outside of its largest
+// hole, from 0 (all free space is contiguous) to close to 1
+func fragmentation(bucket map[string]BucketItem, offsets map[string]int64, limit int64) float64 {
+	used := make([]offsetRange, 0, len(bucket))
+	for k, v := range bucket {
+		used = append(used, offsetRange{Start: offsets[k], End: offsets[k] + v.footprint()})
+	}
+	slices.SortFunc(used, func(a, b offsetRange) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	free, largest := int64(0), int64(0)
+	for _, hole := range freeRanges(used, limit) {
+		free += hole.End - hole.Start
+		largest = max(largest, hole.End-hole.Start)
+	}
+	if free == 0 {
+		return 0
+	}
+	return 1 - float64(largest)/float64(free)
+}
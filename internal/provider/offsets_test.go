@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllocateOffsets(t *testing.T) {
+	bucket := map[string]BucketItem{
+		"a": {Weight: 30},
+		"b": {Weight: 20},
+		"c": {Weight: 10},
+	}
+	offsets, unallocated := allocateOffsets(bucket, map[string]int64{}, map[string]bool{}, 100)
+	expected := map[string]int64{"a": 0, "b": 30, "c": 50}
+	if !reflect.DeepEqual(offsets, expected) || len(unallocated) > 0 {
+		t.Errorf("Expected %v got %v (unallocated %v)", expected, offsets, unallocated)
+	}
+}
+
+func TestAllocateOffsetsFirstFit(t *testing.T) {
+	bucket := map[string]BucketItem{
+		"a": {Weight: 30},
+		"c": {Weight: 30},
+		"d": {Weight: 20},
+		"e": {Weight: 30},
+	}
+	// d fits in the hole left by a removed item, e doesn't fit anywhere
+	offsets, unallocated := allocateOffsets(bucket, map[string]int64{"a": 0, "c": 60}, map[string]bool{}, 100)
+	expected := map[string]int64{"a": 0, "c": 60, "d": 30}
+	if !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("Expected %v got %v", expected, offsets)
+	}
+	if !reflect.DeepEqual(unallocated, []string{"e"}) {
+		t.Errorf("Expected e to be unallocated, got %v", unallocated)
+	}
+}
+
+func TestAllocateOffsetsGrown(t *testing.T) {
+	bucket := map[string]BucketItem{
+		"a": {Weight: 40},
+		"b": {Weight: 30},
+	}
+	// The grown item overlaps the next one, which keeps its offset
+	offsets, unallocated := allocateOffsets(bucket, map[string]int64{"a": 0, "b": 30}, map[string]bool{"a": true}, 100)
+	expected := map[string]int64{"a": 60, "b": 30}
+	if !reflect.DeepEqual(offsets, expected) || len(unallocated) > 0 {
+		t.Errorf("Expected %v got %v (unallocated %v)", expected, offsets, unallocated)
+	}
+
+	// Offsets past a lowered limit are allocated again
+	offsets, unallocated = allocateOffsets(bucket, map[string]int64{"a": 60, "b": 30}, map[string]bool{}, 80)
+	if !reflect.DeepEqual(unallocated, []string{"a"}) {
+		t.Errorf("Expected a to be unallocated, got %v", unallocated)
+	}
+	expected = map[string]int64{"b": 30}
+	if !reflect.DeepEqual(offsets, expected) {
+		t.Errorf("Expected %v got %v", expected, offsets)
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	bucket := map[string]BucketItem{
+		"a": {Weight: 30},
+		"c": {Weight: 30},
+	}
+	if f := fragmentation(bucket, map[string]int64{"a": 0, "c": 30}, 100); f != 0 {
+		t.Errorf("Expected no fragmentation, got %f", f)
+	}
+	// Holes of 30 and 10
+	if f := fragmentation(bucket, map[string]int64{"a": 0, "c": 60}, 100); f != 0.25 {
+		t.Errorf("Expected fragmentation of 0.25, got %f", f)
+	}
+	full := map[string]BucketItem{"a": {Weight: 100}}
+	if f := fragmentation(full, map[string]int64{"a": 0}, 100); f != 0 {
+		t.Errorf("Expected no fragmentation for a full bucket, got %f", f)
+	}
+}
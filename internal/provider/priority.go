@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+// unitPriority returns the highest priority of the items in the unit
+func unitPriority(unit map[string]BucketItem) int64 {
+	first := true
+	priority := int64(0)
+	for _, v := range unit {
+		if first || v.Priority > priority {
+			priority = v.Priority
+			first = false
+		}
+	}
+	return priority
+}
+
+// priorityEvictions selects the units of lower priority to evict from the bucket so that the unit
+// fits in it. Units of the lowest priority are evicted first, the heaviest first within the same
+// priority. Units that turn out not to be needed to make room are kept. Returns false if the unit
+// doesn't fit even after evicting all the lower priority units.
+func priorityEvictions(bucket map[string]BucketItem, used int64, unit map[string]BucketItem, unitWeight int64, capacity int64, maxItems int) ([]map[string]BucketItem, bool) {
+	priority := unitPriority(unit)
+	candidates := make([]map[string]BucketItem, 0)
+	candidateWeights := make(map[int]int64, 0)
+	units, weights := bucketUnits(bucket)
+	for idx, candidate := range units {
+		if unitPriority(candidate) < priority {
+			candidateWeights[len(candidates)] = weights[idx]
+			candidates = append(candidates, candidate)
+		}
+	}
+	order := make([]int, len(candidates))
+	for idx := range order {
+		order[idx] = idx
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		if pa, pb := unitPriority(candidates[a]), unitPriority(candidates[b]); pa != pb {
+			return cmp.Compare(pa, pb)
+		}
+		return cmp.Compare(candidateWeights[b], candidateWeights[a])
+	})
+
+	remaining := maps.Clone(bucket)
+	fits := func() bool {
+		if used+unitWeight > capacity {
+			return false
+		}
+		if maxItems > 0 && len(remaining)+len(unit) > maxItems {
+			return false
+		}
+		return !antiAffinityConflict(remaining, unit)
+	}
+
+	evicted := make([]int, 0)
+	for _, idx := range order {
+		if fits() {
+			break
+		}
+		for k := range candidates[idx] {
+			delete(remaining, k)
+		}
+		used -= candidateWeights[idx]
+		evicted = append(evicted, idx)
+	}
+	if !fits() {
+		return nil, false
+	}
+
+	// Keep the units that didn't need to be evicted after all, most important first
+	kept := make(map[int]bool, 0)
+	for i := len(evicted) - 1; i >= 0; i-- {
+		idx := evicted[i]
+		maps.Copy(remaining, candidates[idx])
+		used += candidateWeights[idx]
+		if fits() {
+			kept[idx] = true
+			continue
+		}
+		for k := range candidates[idx] {
+			delete(remaining, k)
+		}
+		used -= candidateWeights[idx]
+	}
+
+	result := make([]map[string]BucketItem, 0, len(evicted))
+	for _, idx := range evicted {
+		if !kept[idx] {
+			result = append(result, candidates[idx])
+		}
+	}
+	return result, true
+}
+
+// unitsCost is the cost of evicting a set of units, compared by the highest priority
+// evicted first and by the evicted weight second
+type unitsCost struct {
+	priority int64
+	weight   int64
+}
+
+func (c unitsCost) less(other unitsCost) bool {
+	if c.priority != other.priority {
+		return c.priority < other.priority
+	}
+	return c.weight < other.weight
+}
+
+// evictionCost returns the cost of evicting the units
+func evictionCost(units []map[string]BucketItem) unitsCost {
+	cost := unitsCost{}
+	for idx, unit := range units {
+		if priority := unitPriority(unit); idx == 0 || priority > cost.priority {
+			cost.priority = priority
+		}
+		for _, v := range unit {
+			cost.weight += v.footprint()
+		}
+	}
+	return cost
+}
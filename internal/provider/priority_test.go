@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func evictedKeys(units []map[string]BucketItem) []string {
+	keys := make([]string, 0)
+	for _, unit := range units {
+		keys = append(keys, slices.Sorted(maps.Keys(unit))...)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestPriorityEvictions(t *testing.T) {
+	bucket := map[string]BucketItem{
+		"a": {Weight: 40, Priority: 1},
+		"b": {Weight: 30},
+		"c": {Weight: 20},
+		"d": {Weight: 10, Priority: -1},
+	}
+	unit := map[string]BucketItem{"new": {Weight: 30, Priority: 1}}
+	// Lowest priority first, then the heaviest, but d turns out not to be needed
+	evicted, ok := priorityEvictions(bucket, 100, unit, 30, 100, 0)
+	if !ok {
+		t.Fatalf("Expected the unit to fit")
+	}
+	if keys := evictedKeys(evicted); !slices.Equal(keys, []string{"b"}) {
+		t.Errorf("Expected b to be evicted, got %v", keys)
+	}
+
+	// Items of the same or higher priority are never evicted
+	unit = map[string]BucketItem{"new": {Weight: 70, Priority: 1}}
+	if _, ok := priorityEvictions(bucket, 100, unit, 70, 100, 0); ok {
+		t.Errorf("Expected the unit not to fit")
+	}
+}
+
+func TestPriorityEvictionsAffinity(t *testing.T) {
+	bucket := map[string]BucketItem{
+		"a": {Weight: 20, AffinityGroup: "svc"},
+		"b": {Weight: 20, AffinityGroup: "svc", Priority: 2},
+		"c": {Weight: 50},
+	}
+	unit := map[string]BucketItem{"new": {Weight: 40, Priority: 1}}
+	// The affinity group takes the highest priority of its items
+	evicted, ok := priorityEvictions(bucket, 90, unit, 40, 100, 0)
+	if !ok {
+		t.Fatalf("Expected the unit to fit")
+	}
+	if keys := evictedKeys(evicted); !slices.Equal(keys, []string{"c"}) {
+		t.Errorf("Expected c to be evicted, got %v", keys)
+	}
+}
+
+func TestPriorityEvictionsMaxItems(t *testing.T) {
+	bucket := map[string]BucketItem{
+		"a": {Weight: 10},
+		"b": {Weight: 20},
+	}
+	unit := map[string]BucketItem{"new": {Weight: 10, Priority: 1}}
+	evicted, ok := priorityEvictions(bucket, 30, unit, 10, 100, 2)
+	if !ok {
+		t.Fatalf("Expected the unit to fit")
+	}
+	if keys := evictedKeys(evicted); !slices.Equal(keys, []string{"b"}) {
+		t.Errorf("Expected b to be evicted, got %v", keys)
+	}
+}
+
+func TestEvictionCost(t *testing.T) {
+	low := evictionCost([]map[string]BucketItem{{"a": {Weight: 50, Priority: -1}}})
+	high := evictionCost([]map[string]BucketItem{{"b": {Weight: 10}}})
+	if !low.less(high) || high.less(low) {
+		t.Errorf("Expected lower priority to be cheaper: %v %v", low, high)
+	}
+	light := evictionCost([]map[string]BucketItem{{"c": {Weight: 5}}})
+	if !light.less(high) {
+		t.Errorf("Expected less weight to be cheaper: %v %v", light, high)
+	}
+}
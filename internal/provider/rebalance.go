@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"slices"
+)
+
+const (
+	rebalancePack   = "pack"
+	rebalanceSpread = "spread"
+)
+
+// rebalanceBuckets moves items between buckets either to use as few buckets as possible (pack)
+// or to even out the utilization of the buckets (spread). No bucket is filled over its limit,
+// canPlace is consulted for any other constraints and at most maxMoves items are moved (a
+// negative value means no limit). Returns the number of items moved.
+func rebalanceBuckets(allBuckets []map[string]BucketItem, capacities []int64, keysInBuckets map[string]int, strategy string, limits []int64, canPlace func(int, map[string]BucketItem) bool, maxMoves int) int {
+	if strategy == rebalanceSpread {
+		return spreadBuckets(allBuckets, capacities, keysInBuckets, limits, canPlace, maxMoves)
+	}
+	return packBuckets(allBuckets, capacities, keysInBuckets, limits, canPlace, maxMoves)
+}
+
+// packBuckets empties the least utilized buckets by moving their items into the fullest
+// buckets that still have room for them
+func packBuckets(allBuckets []map[string]BucketItem, capacities []int64, keysInBuckets map[string]int, limits []int64, canPlace func(int, map[string]BucketItem) bool, maxMoves int) int {
+	moved := 0
+	tried := make(map[int]bool, 0)
+	for {
+		// Prefer emptying later buckets when utilization is equal
+		source := -1
+		for idx, used := range capacities {
+			if len(allBuckets[idx]) > 0 && !tried[idx] && (source < 0 || used <= capacities[source]) {
+				source = idx
+			}
+		}
+		if source < 0 {
+			return moved
+		}
+		tried[source] = true
+		if maxMoves >= 0 && moved+len(allBuckets[source]) > maxMoves {
+			continue
+		}
+
+		units, weights := bucketUnits(allBuckets[source])
+		order := make([]int, len(units))
+		for idx := range order {
+			order[idx] = idx
+		}
+		slices.SortStableFunc(order, func(a, b int) int {
+			return int(weights[b] - weights[a])
+		})
+
+		type placement struct {
+			unit int
+			to   int
+		}
+		placements := make([]placement, 0, len(units))
+		for _, uidx := range order {
+			target := -1
+			for idx, used := range capacities {
+				if idx == source || len(allBuckets[idx]) == 0 || used+weights[uidx] > limits[idx] {
+					continue
+				}
+				if !canPlace(idx, units[uidx]) {
+					continue
+				}
+				if target < 0 || used > capacities[target] {
+					target = idx
+				}
+			}
+			if target < 0 {
+				break
+			}
+			moveItems(allBuckets, capacities, keysInBuckets, units[uidx], source, target)
+			placements = append(placements, placement{unit: uidx, to: target})
+		}
+
+		if len(placements) < len(units) {
+			// The bucket can't be emptied, put back what was already moved
+			for _, p := range placements {
+				moveItems(allBuckets, capacities, keysInBuckets, units[p.unit], p.to, source)
+			}
+			continue
+		}
+		for _, unit := range units {
+			moved += len(unit)
+		}
+	}
+}
+
+// spreadBuckets moves items from the fullest bucket to the least utilized buckets as long as
+// that narrows the gap between them
+func spreadBuckets(allBuckets []map[string]BucketItem, capacities []int64, keysInBuckets map[string]int, limits []int64, canPlace func(int, map[string]BucketItem) bool, maxMoves int) int {
+	moved := 0
+	for maxMoves < 0 || moved < maxMoves {
+		fullest := 0
+		for idx, used := range capacities {
+			if used > capacities[fullest] {
+				fullest = idx
+			}
+		}
+		targets := make([]int, 0, len(capacities))
+		for idx := range capacities {
+			if idx != fullest {
+				targets = append(targets, idx)
+			}
+		}
+		slices.SortStableFunc(targets, func(a, b int) int {
+			return int(capacities[a] - capacities[b])
+		})
+
+		units, weights := bucketUnits(allBuckets[fullest])
+		pick, target := -1, -1
+		for _, idx := range targets {
+			for uidx, weight := range weights {
+				// Moving the unit has to leave the target below the current maximum
+				if capacities[idx]+weight >= capacities[fullest] || capacities[idx]+weight > limits[idx] {
+					continue
+				}
+				if maxMoves >= 0 && moved+len(units[uidx]) > maxMoves {
+					continue
+				}
+				if !canPlace(idx, units[uidx]) {
+					continue
+				}
+				// Prefer the unit that splits the gap most evenly
+				gap := capacities[fullest] - capacities[idx]
+				if pick < 0 || abs(2*weight-gap) < abs(2*weights[pick]-gap) {
+					pick = uidx
+				}
+			}
+			if pick >= 0 {
+				target = idx
+				break
+			}
+		}
+		if pick < 0 {
+			return moved
+		}
+		moveItems(allBuckets, capacities, keysInBuckets, units[pick], fullest, target)
+		moved += len(units[pick])
+	}
+	return moved
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func makeBuckets(weights []map[string]int64) ([]map[string]BucketItem, []int64, map[string]int) {
+	allBuckets := make([]map[string]BucketItem, len(weights))
+	capacities := make([]int64, len(weights))
+	keysInBuckets := make(map[string]int, 0)
+	for idx, bucket := range weights {
+		allBuckets[idx] = make(map[string]BucketItem, len(bucket))
+		for k, w := range bucket {
+			allBuckets[idx][k] = BucketItem{Weight: w}
+			capacities[idx] += w
+			keysInBuckets[k] = idx
+		}
+	}
+	return allBuckets, capacities, keysInBuckets
+}
+
+func sameLimits(count int, capacity int64) []int64 {
+	limits := make([]int64, count)
+	for idx := range limits {
+		limits[idx] = capacity
+	}
+	return limits
+}
+
+func antiAffinity(allBuckets []map[string]BucketItem) func(int, map[string]BucketItem) bool {
+	return func(idx int, unit map[string]BucketItem) bool {
+		return !antiAffinityConflict(allBuckets[idx], unit)
+	}
+}
+
+func TestRebalancePack(t *testing.T) {
+	allBuckets, capacities, keysInBuckets := makeBuckets([]map[string]int64{
+		{"a": 50},
+		{"b": 20},
+		{"c": 30, "d": 10},
+	})
+	moved := rebalanceBuckets(allBuckets, capacities, keysInBuckets, rebalancePack, sameLimits(len(capacities), 100), antiAffinity(allBuckets), -1)
+	expected := map[string]int{"a": 0, "b": 0, "c": 2, "d": 2}
+	t.Logf("buckets: %v, capacities: %v", keysInBuckets, capacities)
+	if !reflect.DeepEqual(keysInBuckets, expected) {
+		t.Errorf("Expected %v got %v", expected, keysInBuckets)
+	}
+	if moved != 1 {
+		t.Errorf("Expected 1 move, got %d", moved)
+	}
+	if !reflect.DeepEqual(capacities, []int64{70, 0, 40}) {
+		t.Errorf("Unexpected capacities %v", capacities)
+	}
+}
+
+func TestRebalancePackMaxMoves(t *testing.T) {
+	allBuckets, capacities, keysInBuckets := makeBuckets([]map[string]int64{
+		{"a": 10},
+		{"b": 10, "c": 10},
+		{"d": 10, "e": 10, "f": 10},
+	})
+	// Emptying bucket 0 takes one move, bucket 1 would need two more
+	moved := rebalanceBuckets(allBuckets, capacities, keysInBuckets, rebalancePack, sameLimits(len(capacities), 100), antiAffinity(allBuckets), 2)
+	if moved != 1 || keysInBuckets["a"] != 2 {
+		t.Errorf("Expected a single move of a, got %d moves: %v", moved, keysInBuckets)
+	}
+	moved = rebalanceBuckets(allBuckets, capacities, keysInBuckets, rebalancePack, sameLimits(len(capacities), 100), antiAffinity(allBuckets), 2)
+	expected := map[string]int{"a": 2, "b": 2, "c": 2, "d": 2, "e": 2, "f": 2}
+	if moved != 2 || !reflect.DeepEqual(keysInBuckets, expected) {
+		t.Errorf("Expected 2 moves and %v, got %d moves and %v", expected, moved, keysInBuckets)
+	}
+}
+
+func TestRebalanceSpread(t *testing.T) {
+	allBuckets, capacities, keysInBuckets := makeBuckets([]map[string]int64{
+		{"a": 40, "b": 30, "c": 20},
+		{},
+		{"d": 10},
+	})
+	moved := rebalanceBuckets(allBuckets, capacities, keysInBuckets, rebalanceSpread, sameLimits(len(capacities), 100), antiAffinity(allBuckets), -1)
+	t.Logf("buckets: %v, capacities: %v", keysInBuckets, capacities)
+	if moved != 2 {
+		t.Errorf("Expected 2 moves, got %d", moved)
+	}
+	if !reflect.DeepEqual(capacities, []int64{30, 40, 30}) {
+		t.Errorf("Unexpected capacities %v", capacities)
+	}
+}
+
+func TestRebalanceAntiAffinity(t *testing.T) {
+	allBuckets, capacities, keysInBuckets := makeBuckets([]map[string]int64{
+		{"a": 10},
+		{"b": 10},
+	})
+	for idx, k := range []string{"a", "b"} {
+		item := allBuckets[idx][k]
+		item.AntiAffinityGroup = "shard"
+		allBuckets[idx][k] = item
+	}
+	moved := rebalanceBuckets(allBuckets, capacities, keysInBuckets, rebalancePack, sameLimits(len(capacities), 100), antiAffinity(allBuckets), -1)
+	if moved != 0 {
+		t.Errorf("Expected no moves, got %d: %v", moved, keysInBuckets)
+	}
+}
+
+func TestRebalancePackLimits(t *testing.T) {
+	allBuckets, capacities, keysInBuckets := makeBuckets([]map[string]int64{
+		{"a": 50},
+		{"b": 60},
+		{"c": 10},
+	})
+	// The overcommitted bucket takes all the items
+	moved := rebalanceBuckets(allBuckets, capacities, keysInBuckets, rebalancePack, []int64{100, 150, 100}, antiAffinity(allBuckets), -1)
+	expected := map[string]int{"a": 1, "b": 1, "c": 1}
+	if !reflect.DeepEqual(keysInBuckets, expected) {
+		t.Errorf("Expected %v got %v", expected, keysInBuckets)
+	}
+	if moved != 2 {
+		t.Errorf("Expected 2 moves, got %d", moved)
+	}
+}
@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+var remapObjectType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"trigger": types.StringType,
+		"mapping": types.ListType{ElemType: types.Int64Type},
+		"merge":   types.Int64Type,
+		"split":   types.Int64Type,
+	},
+}
+
+// bucketRemap maps the buckets in the state to new indexes, either explicitly, by merging
+// consecutive buckets or by splitting every bucket into consecutive buckets
+type bucketRemap struct {
+	Mapping []int
+	Merge   int
+	Split   int
+}
+
+// remapTrigger returns the trigger of the bucket_remap attribute, null when it isn't set
+func remapTrigger(remap types.Object) types.String {
+	if remap.IsNull() || remap.IsUnknown() {
+		return types.StringNull()
+	}
+	if trigger, ok := remap.Attributes()["trigger"].(basetypes.StringValue); ok {
+		return trigger
+	}
+	return types.StringNull()
+}
+
+// parseRemap reads the rule of the bucket_remap attribute, exactly one of mapping, merge and
+// split has to be set
+func parseRemap(remap types.Object) (bucketRemap, error) {
+	var rule bucketRemap
+	set := 0
+	attrs := remap.Attributes()
+	if mapping, ok := attrs["mapping"].(basetypes.ListValue); ok && !mapping.IsNull() {
+		set++
+		rule.Mapping = make([]int, 0, len(mapping.Elements()))
+		for _, v := range mapping.Elements() {
+			if bidx, ok := v.(basetypes.Int64Value); ok {
+				rule.Mapping = append(rule.Mapping, int(bidx.ValueInt64()))
+			}
+		}
+	}
+	if merge, ok := attrs["merge"].(basetypes.Int64Value); ok && !merge.IsNull() {
+		set++
+		rule.Merge = int(merge.ValueInt64())
+	}
+	if split, ok := attrs["split"].(basetypes.Int64Value); ok && !split.IsNull() {
+		set++
+		rule.Split = int(split.ValueInt64())
+	}
+	if set != 1 {
+		return rule, fmt.Errorf("exactly one of mapping, merge and split must be set")
+	}
+	return rule, nil
+}
+
+// targets returns the new indexes the items of a bucket are remapped to. Buckets past the end
+// of an explicit mapping keep their index.
+func (r bucketRemap) targets(bidx int) []int {
+	switch {
+	case r.Split > 0:
+		targets := make([]int, r.Split)
+		for idx := range targets {
+			targets[idx] = bidx*r.Split + idx
+		}
+		return targets
+	case r.Merge > 0:
+		return []int{bidx / r.Merge}
+	case bidx < len(r.Mapping):
+		return []int{r.Mapping[bidx]}
+	}
+	return []int{bidx}
+}
+
+// validate checks that the buckets in the state are remapped to buckets that exist
+func (r bucketRemap) validate(stateCount, bucketCount int) error {
+	for bidx := 0; bidx < stateCount; bidx++ {
+		if r.Split == 0 && r.Merge == 0 && bidx >= len(r.Mapping) {
+			continue
+		}
+		for _, target := range r.targets(bidx) {
+			if target >= bucketCount {
+				return fmt.Errorf("bucket %d is remapped to bucket %d, past maximum_buckets (%d)", bidx, target, bucketCount)
+			}
+		}
+	}
+	return nil
+}
+
+// remapBuckets returns the buckets with their items at their new indexes. The items of a split
+// bucket are spread by affinity unit in key order, each unit going to the first new bucket it fits
+// in, or to the least used one when it doesn't fit anywhere. Buckets may end up over their limits.
+func remapBuckets(buckets []map[string]BucketItem, rule bucketRemap, limits []int64) []map[string]BucketItem {
+	remapped := make([]map[string]BucketItem, len(buckets))
+	used := make([]int64, len(buckets))
+	for idx := range remapped {
+		remapped[idx] = make(map[string]BucketItem, 0)
+	}
+	for bidx, bucket := range buckets {
+		targets := rule.targets(bidx)
+		units, weights := bucketUnits(bucket)
+		for uidx, unit := range units {
+			target := targets[0]
+			for _, idx := range targets {
+				if used[idx]+weights[uidx] <= limits[idx] {
+					target = idx
+					break
+				}
+				if used[idx] < used[target] {
+					target = idx
+				}
+			}
+			for k, v := range unit {
+				remapped[target][k] = v
+			}
+			used[target] += weights[uidx]
+		}
+	}
+	return remapped
+}
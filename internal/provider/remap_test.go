@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestRemapTargets(t *testing.T) {
+	merge := bucketRemap{Merge: 2}
+	for bidx, expected := range []int{0, 0, 1, 1} {
+		if targets := merge.targets(bidx); !slices.Equal(targets, []int{expected}) {
+			t.Errorf("Expected bucket %d to be merged into %d, got %v", bidx, expected, targets)
+		}
+	}
+	split := bucketRemap{Split: 2}
+	if targets := split.targets(1); !slices.Equal(targets, []int{2, 3}) {
+		t.Errorf("Expected bucket 1 to be split into 2 and 3, got %v", targets)
+	}
+	mapping := bucketRemap{Mapping: []int{1, 0}}
+	if targets := mapping.targets(2); !slices.Equal(targets, []int{2}) {
+		t.Errorf("Expected an unmapped bucket to keep its index, got %v", targets)
+	}
+
+	if err := split.validate(2, 4); err != nil {
+		t.Errorf("Expected the split to fit, got %v", err)
+	}
+	if err := split.validate(3, 4); err == nil {
+		t.Errorf("Expected an error for remapping past maximum_buckets")
+	}
+	if err := mapping.validate(3, 2); err != nil {
+		t.Errorf("Expected unmapped buckets to be left to the evacuation, got %v", err)
+	}
+}
+
+func TestRemapBuckets(t *testing.T) {
+	buckets := []map[string]BucketItem{
+		{"a": {Weight: 10}, "b": {Weight: 10}, "c": {Weight: 5, AffinityGroup: "g"}, "d": {Weight: 5, AffinityGroup: "g"}},
+		{},
+		{},
+	}
+	remapped := remapBuckets(buckets, bucketRemap{Split: 3}, []int64{10, 10, 10})
+	for bidx, expected := range [][]string{{"a"}, {"b"}, {"c", "d"}} {
+		if keys := slices.Sorted(maps.Keys(remapped[bidx])); !slices.Equal(keys, expected) {
+			t.Errorf("Expected bucket %d to hold %v, got %v", bidx, expected, keys)
+		}
+	}
+
+	// Units that don't fit anywhere go to the least used bucket
+	remapped = remapBuckets(buckets, bucketRemap{Split: 2}, []int64{10, 10, 10})
+	for bidx, expected := range [][]string{{"a", "c", "d"}, {"b"}} {
+		if keys := slices.Sorted(maps.Keys(remapped[bidx])); !slices.Equal(keys, expected) {
+			t.Errorf("Expected bucket %d to hold %v, got %v", bidx, expected, keys)
+		}
+	}
+}
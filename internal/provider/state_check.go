@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
+)
+
+const repairHint = "Set repair_state to true to keep the first occurrence of each item. If the state can't be refreshed, apply with -refresh=false."
+
+// duplicateItem is an item that is also in an earlier bucket
+type duplicateItem struct {
+	Key    string
+	Bucket int
+	First  int
+}
+
+// removeDuplicates keeps only the first occurrence of each item in the buckets, and returns the
+// removed items ordered by bucket and key
+func removeDuplicates(buckets []map[string]attr.Value) []duplicateItem {
+	duplicates := make([]duplicateItem, 0)
+	seen := make(map[string]int, 0)
+	for bidx, bucket := range buckets {
+		for _, k := range slices.Sorted(maps.Keys(bucket)) {
+			if first, ok := seen[k]; ok {
+				duplicates = append(duplicates, duplicateItem{Key: k, Bucket: bidx, First: first})
+				delete(bucket, k)
+				continue
+			}
+			seen[k] = bidx
+		}
+	}
+	return duplicates
+}
+
+// stateBucketItems returns the items of each bucket in the state
+func stateBucketItems(buckets types.List) []map[string]attr.Value {
+	items := make([]map[string]attr.Value, 0, len(buckets.Elements()))
+	for _, bucket := range buckets.Elements() {
+		bucketItems := make(map[string]attr.Value, 0)
+		if m, ok := bucket.(basetypes.MapValue); ok {
+			maps.Copy(bucketItems, m.Elements())
+		}
+		items = append(items, bucketItems)
+	}
+	return items
+}
+
+// checkDuplicates reports the items that are in more than one bucket of the state. With repair
+// the later occurrences are removed from the returned buckets.
+func checkDuplicates(buckets types.List, repair bool, diagnostics *diag.Diagnostics) types.List {
+	if buckets.IsNull() || buckets.IsUnknown() {
+		return buckets
+	}
+	items := stateBucketItems(buckets)
+	duplicates := removeDuplicates(items)
+	for _, d := range duplicates {
+		summary := fmt.Sprintf("item %s is in both bucket %d and bucket %d", d.Key, d.First, d.Bucket)
+		itemPath := path.Root("buckets").AtListIndex(d.Bucket).AtMapKey(d.Key)
+		if repair {
+			diagnostics.AddAttributeWarning(itemPath, summary, fmt.Sprintf("Repaired by keeping the item in bucket %d.", d.First))
+		} else {
+			diagnostics.AddAttributeError(itemPath, summary, repairHint)
+		}
+	}
+	if !repair || len(duplicates) == 0 {
+		return buckets
+	}
+	tfBuckets := make([]attr.Value, 0, len(items))
+	for _, bucketItems := range items {
+		tfBucket, diags := types.MapValue(itemObjectType, bucketItems)
+		diagnostics.Append(diags...)
+		tfBuckets = append(tfBuckets, tfBucket)
+	}
+	repaired, diags := types.ListValue(bucketsType, tfBuckets)
+	diagnostics.Append(diags...)
+	return repaired
+}
+
+// checkState validates the buckets in the state against the configuration of the last apply:
+// every item is in a single bucket, the buckets are within their capacity and there are no more
+// buckets than maximum_buckets. With repair_state only the first occurrence of each item is kept,
+// and the other problems are left to the next plan, which moves the items.
+func checkState(data *PersistentBucketsResourceModel, diagnostics *diag.Diagnostics) {
+	repair := data.RepairState.ValueBool()
+	data.Buckets = checkDuplicates(data.Buckets, repair, diagnostics)
+	if data.Buckets.IsNull() || data.Buckets.IsUnknown() {
+		return
+	}
+	report := diagnostics.AddAttributeError
+	detail := "The state was modified outside of Terraform."
+	if repair {
+		report = diagnostics.AddAttributeWarning
+		detail = "The items are moved by the next plan."
+	}
+
+	buckets := stateBucketItems(data.Buckets)
+	// An imported layout has no configuration in the state yet
+	if !data.MaximumBuckets.IsNull() && int64(len(buckets)) > data.MaximumBuckets.ValueInt64() {
+		report(path.Root("buckets"), fmt.Sprintf("state has %d buckets, more than maximum_buckets (%d)", len(buckets), data.MaximumBuckets.ValueInt64()), detail)
+	}
+	if data.BucketCapacity.IsNull() {
+		return
+	}
+	reserved := make(map[string]int64, 0)
+	for k, v := range data.Items.Elements() {
+		if item, ok := parseItem(v); ok {
+			reserved[k] = item.ReservedWeight
+		}
+	}
+	ratios := data.BucketOvercommit.Elements()
+	for bidx, bucketItems := range buckets {
+		ratio := 1.0
+		if !data.OvercommitRatio.IsNull() {
+			ratio = data.OvercommitRatio.ValueFloat64()
+		}
+		if bidx < len(ratios) {
+			if bucketRatio, ok := ratios[bidx].(basetypes.Float64Value); ok && !bucketRatio.IsNull() {
+				ratio = bucketRatio.ValueFloat64()
+			}
+		}
+		limit := overcommitLimits(data.BucketCapacity.ValueInt64(), []float64{ratio})[0]
+		used := int64(0)
+		for k, v := range bucketItems {
+			if item, ok := parseItem(v); ok {
+				key := k
+				if _, ok := reserved[key]; !ok && strings.Contains(key, "#") {
+					// Replicas are configured as a single item
+					key = key[:strings.LastIndex(key, "#")]
+				}
+				used += max(item.Weight, reserved[key])
+			}
+		}
+		if used > limit {
+			report(path.Root("buckets").AtListIndex(bidx), fmt.Sprintf("bucket %d holds %d, more than its capacity of %d", bidx, used, limit), detail)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestRemoveDuplicates(t *testing.T) {
+	var diags diag.Diagnostics
+	buckets := stateBucketItems(layoutBuckets([]map[string]int64{{"a": 10, "b": 20}, {"a": 10, "c": 30}, {"b": 20}}, &diags))
+	duplicates := removeDuplicates(buckets)
+	expected := []duplicateItem{{Key: "a", Bucket: 1, First: 0}, {Key: "b", Bucket: 2, First: 0}}
+	if !reflect.DeepEqual(duplicates, expected) {
+		t.Errorf("Expected %v got %v", expected, duplicates)
+	}
+	if len(buckets[0]) != 2 || len(buckets[1]) != 1 || len(buckets[2]) != 0 {
+		t.Errorf("Expected only the first occurrences to be kept, got %v", buckets)
+	}
+}
+
+func testStateModel(layout []map[string]int64, repair bool) *PersistentBucketsResourceModel {
+	var diags diag.Diagnostics
+	return &PersistentBucketsResourceModel{
+		Buckets:          layoutBuckets(layout, &diags),
+		MaximumBuckets:   types.Int64Value(2),
+		BucketCapacity:   types.Int64Value(100),
+		OvercommitRatio:  types.Float64Null(),
+		BucketOvercommit: types.ListNull(types.Float64Type),
+		Items:            types.MapNull(nestedItem.Type()),
+		RepairState:      types.BoolValue(repair),
+	}
+}
+
+func TestCheckState(t *testing.T) {
+	layout := []map[string]int64{{"a": 60, "b": 30}, {"a": 60, "c": 50}}
+	var diags diag.Diagnostics
+	data := testStateModel(layout, false)
+	checkState(data, &diags)
+	if diags.ErrorsCount() != 2 {
+		t.Errorf("Expected a duplicate and a bucket over capacity, got %v", diags)
+	}
+
+	// Repairing drops the duplicate, so the bucket is back within its capacity
+	diags = nil
+	data = testStateModel(layout, true)
+	checkState(data, &diags)
+	if diags.HasError() || diags.WarningsCount() != 1 {
+		t.Errorf("Expected a single warning, got %v", diags)
+	}
+	if items := stateBucketItems(data.Buckets); len(items[1]) != 1 {
+		t.Errorf("Expected the duplicate to be removed, got %v", items)
+	}
+
+	diags = nil
+	checkState(testStateModel([]map[string]int64{{"a": 10}, {}, {}}, false), &diags)
+	if diags.ErrorsCount() != 1 {
+		t.Errorf("Expected too many buckets to be reported, got %v", diags)
+	}
+}